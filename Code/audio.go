@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	defaultAudioCooldown = time.Minute
+	// WhatsApp rejects audio over 16 MB.
+	maxAudioBytes = 16 * 1024 * 1024
+)
+
+// audioMimeTypes lists the formats WhatsApp plays inline, by file extension.
+var audioMimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg; codecs=opus",
+	".opus": "audio/ogg; codecs=opus",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".amr":  "audio/amr",
+}
+
+// AudioTrigger sends one of Files when a message contains Phrase or matches Pattern.
+type AudioTrigger struct {
+	Phrase  string `json:"Phrase"`  // case-insensitive, extra spaces ignored
+	Pattern string `json:"Pattern"` // regular expression, case-insensitive; used instead of Phrase
+	// Files are audio files or folders of them; one is picked at random.
+	Files []string `json:"Files"`
+	// CooldownSeconds is how long a chat waits before the trigger works again (default 60).
+	CooldownSeconds int `json:"CooldownSeconds"`
+}
+
+var defaultAudioTriggers = []AudioTrigger{
+	{Phrase: "bancho, pum x3", Files: []string{"audio/pum"}},
+	{Phrase: "bancho, lofi", Files: []string{"audio/lofi"}},
+	{Phrase: "bancho, noises", Files: []string{"audio/noises"}},
+}
+
+// AudioTriggerList returns the configured triggers, or the built-in ones if
+// AudioTriggers is missing. An empty list turns audio triggers off.
+func (c *Config) AudioTriggerList() []AudioTrigger {
+	if c.AudioTriggers == nil {
+		return defaultAudioTriggers
+	}
+	return c.AudioTriggers
+}
+
+// triggerPatterns caches compiled Patterns, since the trigger list can be reloaded at any time.
+var triggerPatterns sync.Map // string -> *regexp.Regexp
+
+func (t AudioTrigger) key() string {
+	if t.Pattern != "" {
+		return t.Pattern
+	}
+	return normalizeTriggerText(t.Phrase)
+}
+
+func (t AudioTrigger) cooldown() time.Duration {
+	if t.CooldownSeconds > 0 {
+		return time.Duration(t.CooldownSeconds) * time.Second
+	}
+	return defaultAudioCooldown
+}
+
+// Matches reports whether text sets the trigger off.
+func (t AudioTrigger) Matches(text string) (bool, error) {
+	if t.Pattern == "" {
+		phrase := normalizeTriggerText(t.Phrase)
+		return phrase != "" && strings.Contains(normalizeTriggerText(text), phrase), nil
+	}
+
+	re, ok := triggerPatterns.Load(t.Pattern)
+	if !ok {
+		compiled, err := regexp.Compile("(?i)" + t.Pattern)
+		if err != nil {
+			return false, err
+		}
+		re, _ = triggerPatterns.LoadOrStore(t.Pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(text), nil
+}
+
+func normalizeTriggerText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// audioFiles expands paths into the playable audio files they name, looking one level into folders.
+func audioFiles(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Printf("Skipping audio %s: %v\n", path, err)
+			continue
+		}
+		if !info.IsDir() {
+			if _, ok := audioMimeTypes[strings.ToLower(filepath.Ext(path))]; ok {
+				files = append(files, path)
+			}
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			fmt.Printf("Skipping audio folder %s: %v\n", path, err)
+			continue
+		}
+		var found []string
+		for _, entry := range entries {
+			if _, ok := audioMimeTypes[strings.ToLower(filepath.Ext(entry.Name()))]; ok && !entry.IsDir() {
+				found = append(found, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files
+}
+
+// ? ----------------------------------------------Sending----------------------------------------------
+
+// playAudioTriggers sends audio for the first trigger the message matches, unless
+// that trigger already played in this chat within its cooldown. The cooldown is
+// held while the audio is sent and given back if sending fails.
+func (b *Bot) playAudioTriggers(ctx *MessageContext) {
+	for _, trigger := range b.Config().AudioTriggerList() {
+		matched, err := trigger.Matches(ctx.Text)
+		if err != nil {
+			fmt.Printf("Invalid audio trigger pattern %q: %v\n", trigger.Pattern, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		key := ctx.ChatID.String() + "|" + trigger.key()
+		if !takeCooldown(b.AudioCooldowns, key, ctx.Timestamp, trigger.cooldown()) {
+			fmt.Printf("Audio trigger %q is cooling down in %s\n", trigger.key(), ctx.ChatID)
+			return
+		}
+
+		b.Shutdown.Go(func(rootCtx context.Context) {
+			if err := b.sendAudio(rootCtx, ctx.ChatID, trigger.Files); err != nil {
+				fmt.Printf("Failed to send audio for %q: %v\n", trigger.key(), err)
+				// Only audio that actually played counts against the cooldown.
+				releaseCooldown(b.AudioCooldowns, key, ctx.Timestamp, trigger.cooldown())
+			}
+		})
+		return
+	}
+}
+
+// sendAudio sends a random file from paths to chat, uploading it first unless a
+// recent upload is cached.
+func (b *Bot) sendAudio(ctx context.Context, chat types.JID, paths []string) error {
+	files := audioFiles(paths)
+	if len(files) == 0 {
+		return errors.New("no audio files found")
+	}
+	path := files[rand.IntN(len(files))]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxAudioBytes {
+		return fmt.Errorf("%s is %d MB, WhatsApp only takes audio up to 16 MB", path, len(data)/(1024*1024))
+	}
+
+	upload, err := b.uploadCached(ctx, data, whatsmeow.MediaAudio)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", path, err)
+	}
+	return b.Messenger.SendAudio(chat, upload, audioMimeTypes[strings.ToLower(filepath.Ext(path))])
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Bot owns everything a running instance needs. Handlers are methods on it,
+// so tests can build one with a fake Messenger and an in-memory AppDB.
+type Bot struct {
+	mu      sync.RWMutex
+	config  *Config
+	prompts *PromptsConfig
+	work    workCount
+
+	ConfigPath  string
+	PromptsPath string
+
+	// DB is this bot's own view of the app store; its account (the bot's phone
+	// number) namespaces the app data, see SetAccount.
+	DB         *AppDB
+	Messenger  Messenger
+	LLM        LLMClient
+	Supervisor *ConnectionSupervisor
+	Shutdown   *ShutdownCoordinator
+	Stickers   *StickerLibrary
+	// Self holds the bot's own JIDs; see identity.
+	Self *Identity
+	// LIDs and Contacts override the client's stores (tests).
+	LIDs     LIDMapper
+	Contacts ContactLookup
+
+	WhitelistCache        *WhitelistCache
+	AliasCache            *AliasCache
+	ImageDescriptionCache *ImageDescriptionCache
+	AudioCooldowns        *CooldownCache
+	CanonicalCache        *CanonicalCache
+	FeatureCache          *FeatureCache
+
+	StartTime time.Time
+}
+
+func NewBot(config *Config, prompts *PromptsConfig, db *AppDB, messenger Messenger) *Bot {
+	return &Bot{
+		config:    config,
+		prompts:   prompts,
+		DB:        db,
+		Messenger: messenger,
+		Self:      NewIdentity(),
+		WhitelistCache: &WhitelistCache{
+			groups: make(map[string]bool),
+			users:  make(map[string]bool),
+		},
+		AliasCache: &AliasCache{
+			aliases: make(map[string]string),
+		},
+		ImageDescriptionCache: &ImageDescriptionCache{
+			descriptions: make(map[string]string),
+		},
+		AudioCooldowns: &CooldownCache{
+			until: make(map[string]time.Time),
+		},
+		CanonicalCache: &CanonicalCache{
+			lids: make(map[types.JID]types.JID),
+		},
+		FeatureCache: &FeatureCache{
+			disabled: make(map[string]bool),
+		},
+		StartTime: time.Now(),
+	}
+}
+
+func (b *Bot) Config() *Config {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.config
+}
+
+func (b *Bot) Prompts() *PromptsConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.prompts
+}
+
+// Client returns the live whatsmeow client, or nil when running without one (tests).
+func (b *Bot) Client() *whatsmeow.Client {
+	if b.Supervisor == nil {
+		return nil
+	}
+	return b.Supervisor.Client()
+}
+
+// isOwner reports whether jid is the configured OwnerLID, whether it arrives as
+// that LID or as the owner's phone number.
+func (b *Bot) isOwner(jid types.JID) bool {
+	owner, err := types.ParseJID(b.Config().OwnerLID)
+	if err != nil || owner.IsEmpty() {
+		return false
+	}
+	return b.canonicalJID(jid) == b.canonicalJID(owner)
+}
+
+// SetAccount scopes the bot's app data to account once the device's ID is known.
+// The first account ever set also adopts the data from the single-account layout.
+func (b *Bot) SetAccount(ctx context.Context, account string) {
+	b.DB.SetAccount(account)
+	b.Supervisor.SetLabel(account)
+	b.resetCaches()
+	if err := b.DB.ClaimUnownedRows(ctx); err != nil {
+		fmt.Printf("Failed to migrate single-account data: %v\n", err)
+	}
+}
+
+// resetCaches forgets what was cached for the previous account. Each map is
+// cleared in place, since handlers may be holding the caches.
+func (b *Bot) resetCaches() {
+	b.AliasCache.mu.Lock()
+	clear(b.AliasCache.aliases)
+	b.AliasCache.mu.Unlock()
+
+	b.FeatureCache.mu.Lock()
+	clear(b.FeatureCache.disabled)
+	b.FeatureCache.mu.Unlock()
+
+	b.CanonicalCache.mu.Lock()
+	clear(b.CanonicalCache.lids)
+	b.CanonicalCache.mu.Unlock()
+
+	b.WhitelistCache.mu.Lock()
+	clear(b.WhitelistCache.groups)
+	clear(b.WhitelistCache.users)
+	b.WhitelistCache.mu.Unlock()
+}
+
+// syncAccount follows the client's device after a login or re-pair, which can
+// belong to another phone number than before.
+func (b *Bot) syncAccount(ctx context.Context) {
+	client := b.Client()
+	if client == nil || client.Store.ID == nil {
+		return
+	}
+	if account := client.Store.ID.User; account != b.DB.Account() {
+		b.SetAccount(ctx, account)
+	}
+}
+
+// ReloadConfigs re-reads both JSON files and only swaps them in if both parse.
+func (b *Bot) ReloadConfigs() error {
+	config, err := ReadConfig(b.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	prompts, err := ReadPromptsConfig(b.PromptsPath)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.config = config
+	b.prompts = prompts
+	b.mu.Unlock()
+	return nil
+}
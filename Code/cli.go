@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// CLIOptions holds the global flags shared by every subcommand.
+type CLIOptions struct {
+	ConfigPath  string
+	PromptsPath string
+	SessionDSN  string
+	AppDSN      string
+	LogLevel    string
+	Account     string
+	Login       LoginOptions
+
+	ShutdownTimeout time.Duration
+}
+
+const (
+	defaultSessionDSN = "file:session.db?_foreign_keys=on"
+	defaultAppDSN     = "file:app.db?_foreign_keys=on&busy_timeout=5000"
+)
+
+const cliUsage = `Usage: bancho [flags] <command> [arguments]
+
+Commands:
+  run                                   Start the bot (default)
+  login [-new]                          Pair this instance with a phone and exit
+                                        (-new pairs an additional account)
+  logout                                Unlink the device and delete the session
+  migrate                               Create or upgrade the database schemas
+  export [-chat JID] [-limit N] [-out FILE]
+                                        Dump stored message context as JSON
+  whitelist <add|remove|list> <group|user> [JID]
+                                        Manage the group and user whitelists
+  split-db [-from V5.db] [-session session.db] [-app app.db]
+                                        Split an old combined database into session and app files
+  doctor                                Check config, prompts, database and session
+
+Flags:
+`
+
+// runCLI parses the global flags and dispatches to the requested subcommand.
+func runCLI(ctx context.Context, args []string) error {
+	opts := &CLIOptions{}
+	fs := flag.NewFlagSet("bancho", flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigPath, "config", "config.json", "path to the main config file")
+	fs.StringVar(&opts.PromptsPath, "prompts", "prompts.json", "path to the prompts file")
+	fs.StringVar(&opts.SessionDSN, "session-dsn", defaultSessionDSN, "SQLite DSN of the whatsmeow session store")
+	fs.StringVar(&opts.AppDSN, "app-dsn", defaultAppDSN, "SQLite DSN of the app store")
+	fs.StringVar(&opts.LogLevel, "log-level", "WARN", "whatsmeow log level: DEBUG, INFO, WARN or ERROR")
+	fs.StringVar(&opts.Account, "account", "", "phone number of the account to run or administer (default: all for run, first paired otherwise)")
+	fs.StringVar(&opts.Login.PairPhone, "pair-phone", "", "log in with a pairing code for this phone number instead of a QR code")
+	fs.StringVar(&opts.Login.QRFile, "qr-file", "", "also write the login QR code to this PNG file")
+	fs.StringVar(&opts.Login.QRAddr, "qr-http", "", "also serve the login QR code on this address, e.g. 127.0.0.1:8080")
+	fs.DurationVar(&opts.Login.Timeout, "login-timeout", 0, "give up on pairing after this long (0 = until WhatsApp stops issuing codes)")
+	fs.DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "how long to wait for in-flight work on shutdown")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cliUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	opts.LogLevel = strings.ToUpper(strings.TrimSpace(opts.LogLevel))
+	switch opts.LogLevel {
+	case "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		return fmt.Errorf("invalid log level %q", opts.LogLevel)
+	}
+
+	command := "run"
+	rest := fs.Args()
+	if len(rest) > 0 {
+		command, rest = rest[0], rest[1:]
+	}
+
+	switch command {
+	case "run":
+		return cmdRun(ctx, opts)
+	case "login":
+		return cmdLogin(ctx, opts, rest)
+	case "logout":
+		return cmdLogout(ctx, opts)
+	case "migrate":
+		return cmdMigrate(ctx, opts)
+	case "export":
+		return cmdExport(ctx, opts, rest)
+	case "whitelist":
+		return cmdWhitelist(ctx, opts, rest)
+	case "split-db":
+		return cmdSplitDB(ctx, opts, rest)
+	case "doctor":
+		return cmdDoctor(ctx, opts)
+	case "help":
+		fs.Usage()
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------Subcommands--------------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+// cmdRun loads everything and keeps one bot per account online until SIGINT/SIGTERM.
+func cmdRun(ctx context.Context, opts *CLIOptions) error {
+	config, err := ReadConfig(opts.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", opts.ConfigPath, err)
+	}
+	config.DebugPrint()
+
+	prompts, err := ReadPromptsConfig(opts.PromptsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", opts.PromptsPath, err)
+	}
+	prompts.DebugPrint()
+
+	if err := checkLegacyDB(opts.SessionDSN); err != nil {
+		return err
+	}
+
+	appDB, err := OpenAppDB(ctx, opts.AppDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	container, err := openSessionStore(ctx, opts.SessionDSN, opts.LogLevel)
+	if err != nil {
+		return err
+	}
+	accounts := config.Accounts
+	if opts.Account != "" {
+		accounts = []string{opts.Account}
+	}
+	devices, err := loadDevices(ctx, container, accounts)
+	if err != nil {
+		return err
+	}
+
+	shutdown := NewShutdownCoordinator(ctx)
+	supervisorCtx, stopSupervisors := context.WithCancel(ctx)
+	defer stopSupervisors()
+
+	stickerDir := config.StickerDir
+	if stickerDir == "" {
+		stickerDir = defaultStickerDir
+	}
+	stickers, err := LoadStickerLibrary(stickerDir)
+	if err != nil {
+		fmt.Printf("Failed to load stickers from %s: %v\n", stickerDir, err)
+	} else {
+		fmt.Printf("Loaded %d sticker(s) from %s\n", stickers.Len(), stickerDir)
+	}
+
+	var bots []*Bot
+	for _, device := range devices {
+		bot := NewBot(config, prompts, appDB.ForAccount(""), nil)
+		bot.ConfigPath = opts.ConfigPath
+		bot.PromptsPath = opts.PromptsPath
+		bot.Shutdown = shutdown
+		bot.Supervisor = NewConnectionSupervisor(newClient(device, opts.LogLevel), opts.Login, opts.LogLevel, bot.eventHandler)
+		bot.Messenger = NewClientMessenger(bot.Supervisor.Client)
+		bot.LLM = NewDeepSeekClient(config.Token)
+		bot.Stickers = stickers
+
+		// Set before Start so nothing is stored under an empty account; a device
+		// that still has to log in gets it from the PairSuccess event.
+		if device.ID != nil {
+			bot.SetAccount(ctx, device.ID.User)
+		}
+		if err := bot.Supervisor.Start(ctx); err != nil {
+			return err
+		}
+		bot.syncAccount(ctx)
+
+		bot.resumePendingGroups(ctx)
+
+		go bot.Supervisor.Run(supervisorCtx)
+		bots = append(bots, bot)
+		fmt.Printf("Account %s is running.\n", bot.DB.Account())
+	}
+
+	// Listen to Ctrl+C (you can also do something else that prevents the program from exiting)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	fmt.Println("Shutting down, press Ctrl+C again to force.")
+	go func() {
+		<-c
+		fmt.Println("Forced exit.")
+		os.Exit(1)
+	}()
+
+	// Order matters: stop intake -> drain -> disconnect -> close DB,
+	// so nothing touches the client or the database after it is gone.
+	shutdown.StopIntake()
+	if !shutdown.Drain(opts.ShutdownTimeout) {
+		fmt.Println("Some jobs did not finish in time and were abandoned.")
+	}
+	stopSupervisors()
+	for _, bot := range bots {
+		bot.Client().Disconnect()
+	}
+	_ = appDB.Close()
+	return nil
+}
+
+// cmdLogin pairs the session store with a phone and disconnects once paired.
+func cmdLogin(ctx context.Context, opts *CLIOptions, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	addNew := fs.Bool("new", false, "pair an additional account even if one is already logged in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkLegacyDB(opts.SessionDSN); err != nil {
+		return err
+	}
+
+	var client *whatsmeow.Client
+	if *addNew {
+		container, err := openSessionStore(ctx, opts.SessionDSN, opts.LogLevel)
+		if err != nil {
+			return err
+		}
+		client = newClient(container.NewDevice(), opts.LogLevel)
+	} else {
+		var err error
+		client, err = initializeClient(ctx, opts.SessionDSN, opts.LogLevel, opts.Account)
+		if err != nil {
+			return err
+		}
+	}
+	if client.Store.ID != nil {
+		fmt.Printf("Already logged in as %s\n", client.Store.ID.String())
+		return nil
+	}
+
+	if err := connectClient(ctx, client, opts.Login); err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	if client.Store.ID == nil {
+		return errors.New("login did not complete")
+	}
+	fmt.Printf("Logged in as %s\n", client.Store.ID.String())
+	return nil
+}
+
+// cmdLogout unlinks the device from the phone and wipes the local session.
+func cmdLogout(ctx context.Context, opts *CLIOptions) error {
+	client, err := initializeClient(ctx, opts.SessionDSN, opts.LogLevel, opts.Account)
+	if err != nil {
+		return err
+	}
+	if client.Store.ID == nil {
+		fmt.Println("Not logged in.")
+		return nil
+	}
+
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	if !client.WaitForConnection(30 * time.Second) {
+		client.Disconnect()
+		return errors.New("timed out waiting for connection")
+	}
+	if err := client.Logout(ctx); err != nil {
+		client.Disconnect()
+		return err
+	}
+
+	fmt.Println("Logged out.")
+	return nil
+}
+
+// cmdMigrate creates or upgrades both the whatsmeow and app schemas.
+func cmdMigrate(ctx context.Context, opts *CLIOptions) error {
+	container, err := openSessionStore(ctx, opts.SessionDSN, opts.LogLevel)
+	if err != nil {
+		return fmt.Errorf("session store: %w", err)
+	}
+	_ = container.Close()
+
+	appDB, err := OpenAppDB(ctx, opts.AppDSN)
+	if err != nil {
+		return fmt.Errorf("app store: %w", err)
+	}
+	_ = appDB.Close()
+
+	fmt.Println("Migrations applied.")
+	return nil
+}
+
+// cmdExport writes one account's stored message context as a JSON array.
+func cmdExport(ctx context.Context, opts *CLIOptions, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	chat := fs.String("chat", "", "only export this chat JID")
+	limit := fs.Int("limit", 0, "only export the newest N messages (0 = all)")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := resolveAccount(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	rootDB, err := OpenAppDB(ctx, opts.AppDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer rootDB.Close()
+
+	messages, err := rootDB.ForAccount(account).ListMessageContext(ctx, *chat, *limit)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if messages == nil {
+		messages = []StoredMessage{}
+	}
+	return enc.Encode(messages)
+}
+
+// cmdWhitelist adds, removes or lists whitelisted groups and users.
+func cmdWhitelist(ctx context.Context, opts *CLIOptions, args []string) error {
+	const usage = "usage: whitelist <add|remove|list> <group|user> [JID]"
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	action, kind := args[0], args[1]
+	if kind != "group" && kind != "user" {
+		return errors.New(usage)
+	}
+
+	account, err := resolveAccount(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	rootDB, err := OpenAppDB(ctx, opts.AppDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer rootDB.Close()
+	appDB := rootDB.ForAccount(account)
+
+	if action == "list" {
+		var entries []string
+		if kind == "group" {
+			entries, err = appDB.ListWhitelistedGroups(ctx)
+		} else {
+			entries, err = appDB.ListWhitelistedUsers(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fmt.Println(entry)
+		}
+		return nil
+	}
+
+	if len(args) < 3 {
+		return errors.New(usage)
+	}
+	jid, err := types.ParseJID(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid JID %q: %w", args[2], err)
+	}
+
+	switch {
+	case action == "add" && kind == "group":
+		err = appDB.AddGroupToWhitelist(ctx, jid.String())
+	case action == "add" && kind == "user":
+		err = appDB.AddUserToWhitelist(ctx, jid.String())
+	case action == "remove" && kind == "group":
+		err = appDB.RemoveGroupFromWhitelist(ctx, jid.String())
+	case action == "remove" && kind == "user":
+		err = appDB.RemoveUserFromWhitelist(ctx, jid.String())
+	default:
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s: %s (account %s)\n", kind, action, jid.String(), account)
+	return nil
+}
+
+// resolveAccount picks the account that admin commands act on: -account if
+// given, otherwise the first paired device.
+func resolveAccount(ctx context.Context, opts *CLIOptions) (string, error) {
+	if opts.Account != "" {
+		return accountKey(opts.Account), nil
+	}
+	container, err := openSessionStore(ctx, opts.SessionDSN, "ERROR")
+	if err != nil {
+		return "", err
+	}
+	defer container.Close()
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		return "", err
+	}
+	if device.ID == nil {
+		return "", errors.New("no paired account, pass -account")
+	}
+	return device.ID.User, nil
+}
+
+// cmdSplitDB splits an old combined V5.db into a session file and an app file.
+func cmdSplitDB(ctx context.Context, opts *CLIOptions, args []string) error {
+	fs := flag.NewFlagSet("split-db", flag.ContinueOnError)
+	from := fs.String("from", legacyCombinedDB, "combined database to read")
+	sessionPath := fs.String("session", "session.db", "session database to create")
+	appPath := fs.String("app", "app.db", "app database to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := SplitCombinedDB(ctx, *from, *sessionPath, *appPath); err != nil {
+		return err
+	}
+
+	// Make sure the login survived the copy before telling the user to switch over.
+	container, err := openSessionStore(ctx, "file:"+*sessionPath+"?_foreign_keys=on", "ERROR")
+	if err != nil {
+		return fmt.Errorf("split session store does not open: %w", err)
+	}
+	defer container.Close()
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		return err
+	}
+	if device.ID != nil {
+		fmt.Printf("Session for %s copied to %s\n", device.ID.String(), *sessionPath)
+	} else {
+		fmt.Printf("No paired device found in %s, you will need to log in again\n", *from)
+	}
+	fmt.Printf("App data copied to %s\n", *appPath)
+	fmt.Printf("%s was left untouched.\n", *from)
+	return nil
+}
+
+// cmdDoctor runs a series of sanity checks and reports each one.
+func cmdDoctor(ctx context.Context, opts *CLIOptions) error {
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("[ OK ] %s\n", name)
+	}
+
+	config, err := ReadConfig(opts.ConfigPath)
+	check("config "+opts.ConfigPath, err)
+	if config != nil {
+		_, err = types.ParseJID(config.OwnerLID)
+		if err == nil && strings.TrimSpace(config.OwnerLID) == "" {
+			err = errors.New("OwnerLID is empty")
+		}
+		check("owner JID", err)
+
+		err = nil
+		if strings.TrimSpace(config.Token) == "" {
+			err = errors.New("Token is empty")
+		}
+		check("API token", err)
+	}
+
+	_, err = ReadPromptsConfig(opts.PromptsPath)
+	check("prompts "+opts.PromptsPath, err)
+
+	err = nil
+	if opts.SessionDSN == opts.AppDSN {
+		err = errors.New("session and app stores share one database, see `bancho split-db`")
+	}
+	check("store separation", err)
+
+	appDB, err := OpenAppDB(ctx, opts.AppDSN)
+	check("app store "+opts.AppDSN, err)
+	if appDB != nil {
+		_ = appDB.Close()
+	}
+
+	container, err := openSessionStore(ctx, opts.SessionDSN, "ERROR")
+	check("session store "+opts.SessionDSN, err)
+	if container != nil {
+		devices, err := container.GetAllDevices(ctx)
+		if err == nil && len(devices) == 0 {
+			err = errors.New("no device paired, run `bancho login`")
+		}
+		check("session", err)
+		for _, device := range devices {
+			fmt.Printf("       account %s\n", device.ID.User)
+		}
+		_ = container.Close()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
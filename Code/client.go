@@ -3,60 +3,110 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/mdp/qrterminal/v3"
 
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
 	waLog "go.mau.fi/whatsmeow/util/log"
 )
 
 // Whatsmeow shit that I don't understand
 // code from the example of the library 
 
-func initializeClient(ctx context.Context) (*whatsmeow.Client, error) {
-	dbLog := waLog.Stdout("Database", "DEBUG", true)
-	container, err := sqlstore.New(ctx, "sqlite3", "file:V5.db?_foreign_keys=on", dbLog)
-	if err != nil {
-		return nil, err
+// openSessionStore opens (and upgrades) the whatsmeow device store at dsn.
+func openSessionStore(ctx context.Context, dsn string, logLevel string) (*sqlstore.Container, error) {
+	dbLog := waLog.Stdout("Database", logLevel, true)
+	return sqlstore.New(ctx, "sqlite3", dsn, dbLog)
+}
+
+// accountKey reduces a JID or phone number to the bare user part that names an account.
+func accountKey(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "@") {
+		if jid, err := types.ParseJID(value); err == nil {
+			return jid.User
+		}
 	}
+	return strings.TrimPrefix(value, "+")
+}
 
-	deviceStore, err := container.GetFirstDevice(ctx)
+// loadDevices returns the devices to run. With accounts set only those are used,
+// otherwise every paired device is. A fresh device is returned when nothing is
+// paired yet, so the caller can log in.
+func loadDevices(ctx context.Context, container *sqlstore.Container, accounts []string) ([]*store.Device, error) {
+	all, err := container.GetAllDevices(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	clientLog := waLog.Stdout("Client", "WARN", true)
-	client := whatsmeow.NewClient(deviceStore, clientLog)
-	client.AddEventHandler(eventHandler)
-
-	return client, nil
-}
+	if len(accounts) == 0 {
+		if len(all) == 0 {
+			return []*store.Device{container.NewDevice()}, nil
+		}
+		return all, nil
+	}
 
-func connectClient(ctx context.Context, client *whatsmeow.Client) error {
-	if client.Store.ID == nil {
-		// No ID stored, new login
-		qrChan, _ := client.GetQRChannel(ctx)
-		err := client.Connect()
+	var devices []*store.Device
+	for _, account := range accounts {
+		device, err := findDevice(all, accountKey(account))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
-			} else {
-				fmt.Println("Login event:", evt.Event)
-			}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func findDevice(devices []*store.Device, account string) (*store.Device, error) {
+	for _, device := range devices {
+		if device.ID != nil && device.ID.User == account {
+			return device, nil
 		}
-	} else {
-		// Already logged in, just connect
-		err := client.Connect()
+	}
+	return nil, fmt.Errorf("account %s is not paired, run `bancho -account %s login -new`", account, account)
+}
+
+func newClient(device *store.Device, logLevel string) *whatsmeow.Client {
+	clientLog := waLog.Stdout("Client", logLevel, true)
+	return whatsmeow.NewClient(device, clientLog)
+}
+
+// initializeClient opens a client for account, or for the first device when account is empty.
+func initializeClient(ctx context.Context, dsn string, logLevel string, account string) (*whatsmeow.Client, error) {
+	container, err := openSessionStore(ctx, dsn, logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	if account == "" {
+		deviceStore, err := container.GetFirstDevice(ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return newClient(deviceStore, logLevel), nil
+	}
+
+	all, err := container.GetAllDevices(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	deviceStore, err := findDevice(all, accountKey(account))
+	if err != nil {
+		return nil, err
+	}
+	return newClient(deviceStore, logLevel), nil
 }
 
+func connectClient(ctx context.Context, client *whatsmeow.Client, opts LoginOptions) error {
+	if client.Store.ID == nil {
+		// No ID stored, new login
+		return loginClient(ctx, client, opts)
+	}
+
+	// Already logged in, just connect
+	return client.Connect()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdp/qrterminal/v3"
+	"rsc.io/qr"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// LoginOptions controls how a new device is paired.
+type LoginOptions struct {
+	PairPhone string        // phone number in international format, enables pairing-code login
+	QRFile    string        // write each QR code to this PNG file
+	QRAddr    string        // serve the current QR code over HTTP on this address
+	Timeout   time.Duration // give up on pairing after this long (0 = whatsmeow's own limit)
+}
+
+// loginClient pairs a client that has no stored ID yet, using a QR code or a phone pairing code.
+// It returns once pairing succeeded or failed; the client stays connected on success.
+func loginClient(ctx context.Context, client *whatsmeow.Client, opts LoginOptions) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	qrChan, err := client.GetQRChannel(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get QR channel: %w", err)
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+
+	var server *qrServer
+	if opts.QRAddr != "" {
+		server, err = startQRServer(opts.QRAddr)
+		if err != nil {
+			client.Disconnect()
+			return err
+		}
+		defer server.Close()
+		fmt.Printf("Serving login QR code on http://%s/\n", server.Addr())
+	}
+
+	pairCodeRequested := false
+	for evt := range qrChan {
+		switch {
+		case evt.Event == whatsmeow.QRChannelEventCode:
+			if opts.PairPhone != "" {
+				// The first code event means the websocket is ready, which is what PairPhone needs.
+				if pairCodeRequested {
+					continue
+				}
+				pairCodeRequested = true
+				code, err := client.PairPhone(ctx, opts.PairPhone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+				if err != nil {
+					client.Disconnect()
+					return fmt.Errorf("failed to request pairing code: %w", err)
+				}
+				fmt.Printf("Pairing code for %s: %s\n", opts.PairPhone, code)
+				fmt.Println("Open WhatsApp > Linked devices > Link with phone number and enter the code.")
+				server.SetMessage("Pairing code: " + code)
+				continue
+			}
+			showQRCode(evt.Code, opts, server)
+
+		case evt == whatsmeow.QRChannelSuccess:
+			fmt.Println("Login successful.")
+			server.SetMessage("Logged in, you can close this page.")
+			return nil
+
+		case evt == whatsmeow.QRChannelTimeout:
+			return errors.New("login timed out: the code was not used before WhatsApp closed the connection")
+
+		case evt.Event == whatsmeow.QRChannelEventError:
+			client.Disconnect()
+			return fmt.Errorf("pairing failed: %w", evt.Error)
+
+		case strings.HasPrefix(evt.Event, "err-"):
+			client.Disconnect()
+			return fmt.Errorf("login failed (%s): %s", evt.Event, loginErrorHint(evt.Event))
+
+		default:
+			fmt.Println("Login event:", evt.Event)
+		}
+	}
+
+	// The channel also closes without a final item when our own context ends.
+	client.Disconnect()
+	if ctx.Err() != nil {
+		return fmt.Errorf("login timed out after %s", opts.Timeout)
+	}
+	return errors.New("login channel closed unexpectedly")
+}
+
+// loginErrorHint explains the err-* events that GetQRChannel can emit.
+func loginErrorHint(event string) string {
+	switch event {
+	case whatsmeow.QRChannelErrUnexpectedEvent.Event:
+		return "the session changed while pairing, try logging in again"
+	case whatsmeow.QRChannelClientOutdated.Event:
+		return "WhatsApp rejected this client version, update whatsmeow"
+	case whatsmeow.QRChannelScannedWithoutMultidevice.Event:
+		return "the phone scanned the code without multi-device enabled"
+	default:
+		return "unexpected pairing error"
+	}
+}
+
+// showQRCode prints the code to the terminal and mirrors it to the PNG file and HTTP server if configured.
+func showQRCode(code string, opts LoginOptions, server *qrServer) {
+	qrterminal.GenerateHalfBlock(code, qrterminal.L, os.Stdout)
+
+	if opts.QRFile == "" && server == nil {
+		return
+	}
+
+	png, err := renderQRPNG(code)
+	if err != nil {
+		fmt.Printf("Failed to render QR code: %v\n", err)
+		return
+	}
+	if opts.QRFile != "" {
+		if err := os.WriteFile(opts.QRFile, png, 0o600); err != nil {
+			fmt.Printf("Failed to write QR code to %s: %v\n", opts.QRFile, err)
+		} else {
+			fmt.Printf("QR code written to %s\n", opts.QRFile)
+		}
+	}
+	server.SetPNG(png)
+}
+
+func renderQRPNG(code string) ([]byte, error) {
+	c, err := qr.Encode(code, qr.L)
+	if err != nil {
+		return nil, err
+	}
+	c.Scale = 8
+	return c.PNG(), nil
+}
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------QR HTTP Server-----------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+// qrServer serves the latest QR code on a small local page that refreshes itself.
+// All methods are safe to call on a nil server so callers don't need to check.
+type qrServer struct {
+	mu       sync.RWMutex
+	png      []byte
+	message  string
+	listener net.Listener
+	server   *http.Server
+}
+
+func startQRServer(addr string) (*qrServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s := &qrServer{listener: listener, message: "Waiting for QR code..."}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/qr.png", s.handlePNG)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("QR server stopped: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *qrServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *qrServer) Close() {
+	if s == nil {
+		return
+	}
+	_ = s.server.Close()
+}
+
+func (s *qrServer) SetPNG(png []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.png = png
+	s.message = "Scan with WhatsApp > Linked devices"
+	s.mu.Unlock()
+}
+
+func (s *qrServer) SetMessage(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.png = nil
+	s.message = message
+	s.mu.Unlock()
+}
+
+func (s *qrServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	hasPNG := s.png != nil
+	message := s.message
+	s.mu.RUnlock()
+
+	img := ""
+	if hasPNG {
+		img = fmt.Sprintf(`<img src="/qr.png?t=%d" alt="QR code">`, time.Now().UnixNano())
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta http-equiv="refresh" content="5"><title>Bancho login</title></head>`+
+		`<body style="font-family:sans-serif;text-align:center"><p>%s</p>%s</body></html>`, html.EscapeString(message), img)
+}
+
+func (s *qrServer) handlePNG(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	png := s.png
+	s.mu.RUnlock()
+
+	if png == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(png)
+}
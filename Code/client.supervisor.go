@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateLoggingIn
+	StateLoggedOut
+	StateReplaced
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateLoggingIn:
+		return "logging in"
+	case StateLoggedOut:
+		return "logged out"
+	case StateReplaced:
+		return "replaced by another session"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionStatus is a point-in-time copy of the supervisor's bookkeeping.
+type ConnectionStatus struct {
+	State      ConnectionState
+	Since      time.Time
+	LastError  error
+	Reconnects int
+}
+
+func (cs ConnectionStatus) String() string {
+	out := fmt.Sprintf("%s for %s, %d reconnect(s)", cs.State, time.Since(cs.Since).Round(time.Second), cs.Reconnects)
+	if cs.LastError != nil {
+		out += ", last error: " + cs.LastError.Error()
+	}
+	return out
+}
+
+type supervisorAction int
+
+const (
+	actionReconnect supervisorAction = iota
+	actionRelogin
+)
+
+// ConnectionSupervisor keeps the WhatsApp connection alive. It replaces whatsmeow's
+// built-in auto reconnect with exponential backoff and re-enters the login flow
+// when the phone unlinks the device.
+type ConnectionSupervisor struct {
+	mu     sync.RWMutex
+	status ConnectionStatus
+	client *whatsmeow.Client
+
+	login    LoginOptions
+	logLevel string
+	handler  whatsmeow.EventHandler
+	actions  chan supervisorAction
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	label      atomic.Value // string prefixed to log lines when several accounts run in one process
+
+	// Seams for tests; NewConnectionSupervisor fills in the real ones.
+	dial      func(ctx context.Context, client *whatsmeow.Client) error
+	pair      func(ctx context.Context, client *whatsmeow.Client) error
+	newClient func(old *whatsmeow.Client) (*whatsmeow.Client, error)
+	wait      func(ctx context.Context, d time.Duration) bool
+}
+
+// NewConnectionSupervisor takes over client and registers handler on it (and on
+// every client it creates after a re-login).
+func NewConnectionSupervisor(client *whatsmeow.Client, login LoginOptions, logLevel string, handler whatsmeow.EventHandler) *ConnectionSupervisor {
+	client.EnableAutoReconnect = false
+	client.AddEventHandler(handler)
+	s := &ConnectionSupervisor{
+		status:     ConnectionStatus{State: StateDisconnected, Since: time.Now()},
+		client:     client,
+		login:      login,
+		logLevel:   logLevel,
+		handler:    handler,
+		actions:    make(chan supervisorAction, 1),
+		MinBackoff: 2 * time.Second,
+		MaxBackoff: 5 * time.Minute,
+		dial:       dialClient,
+		wait:       sleepContext,
+	}
+	s.pair = func(ctx context.Context, client *whatsmeow.Client) error {
+		return loginClient(ctx, client, s.login)
+	}
+	s.newClient = func(old *whatsmeow.Client) (*whatsmeow.Client, error) {
+		container, ok := old.Store.Container.(*sqlstore.Container)
+		if !ok {
+			return nil, errors.New("session store does not support new devices")
+		}
+		return newClient(container.NewDevice(), s.logLevel), nil
+	}
+	return s
+}
+
+// Client returns the client currently owned by the supervisor; it changes after a re-login.
+func (s *ConnectionSupervisor) Client() *whatsmeow.Client {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.client
+}
+
+func (s *ConnectionSupervisor) Status() ConnectionStatus {
+	if s == nil {
+		return ConnectionStatus{State: StateDisconnected}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+func (s *ConnectionSupervisor) State() ConnectionState {
+	return s.Status().State
+}
+
+func (s *ConnectionSupervisor) setState(state ConnectionState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.State != state {
+		s.logf("Connection state: %s -> %s", s.status.State, state)
+		s.status.State = state
+		s.status.Since = time.Now()
+	}
+	if err != nil {
+		s.status.LastError = err
+	}
+}
+
+// SetLabel sets the prefix of log lines; it can change while the supervisor runs.
+func (s *ConnectionSupervisor) SetLabel(label string) {
+	if s == nil {
+		return
+	}
+	s.label.Store(label)
+}
+
+func (s *ConnectionSupervisor) logf(format string, args ...any) {
+	if label, _ := s.label.Load().(string); label != "" {
+		format = "[" + label + "] " + format
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
+// request queues an action for Run, dropping it if one is already pending.
+func (s *ConnectionSupervisor) request(action supervisorAction) {
+	select {
+	case s.actions <- action:
+	default:
+	}
+}
+
+// Start performs the first connection (logging in if needed).
+func (s *ConnectionSupervisor) Start(ctx context.Context) error {
+	client := s.Client()
+	if client.Store.ID == nil {
+		s.setState(StateLoggingIn, nil)
+	} else {
+		s.setState(StateConnecting, nil)
+	}
+	if err := connectClient(ctx, client, s.login); err != nil {
+		s.setState(StateDisconnected, err)
+		return err
+	}
+	return nil
+}
+
+// Run handles reconnect and re-login requests until ctx is cancelled.
+func (s *ConnectionSupervisor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case action := <-s.actions:
+			switch action {
+			case actionReconnect:
+				s.reconnect(ctx)
+			case actionRelogin:
+				s.relogin(ctx)
+			}
+		}
+	}
+}
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------Event Hooks--------------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+// HandleEvent updates the state from connection events; eventHandler forwards them here.
+func (s *ConnectionSupervisor) HandleEvent(evt interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.setState(StateConnected, nil)
+
+	case *events.Disconnected:
+		if s.State() == StateLoggingIn {
+			return // the QR channel reports its own timeout
+		}
+		s.setState(StateDisconnected, errors.New("connection lost"))
+		s.request(actionReconnect)
+
+	case *events.ConnectFailure:
+		if v.Reason.IsLoggedOut() {
+			return // followed by a LoggedOut event
+		}
+		s.setState(StateDisconnected, fmt.Errorf("connect failure: %s", v.Reason))
+		s.request(actionReconnect)
+
+	case *events.LoggedOut:
+		s.setState(StateLoggedOut, fmt.Errorf("logged out: %s", v.Reason))
+		s.request(actionRelogin)
+
+	case *events.StreamReplaced:
+		// Another process is using this session. Reconnecting would just kick it off again.
+		s.setState(StateReplaced, errors.New("stream replaced by another client"))
+		s.logf("This session was opened somewhere else, not reconnecting.")
+	}
+}
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------Recovery-----------------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+func (s *ConnectionSupervisor) reconnect(ctx context.Context) {
+	delay := s.MinBackoff
+	for attempt := 1; ; attempt++ {
+		switch s.State() {
+		case StateConnected, StateLoggedOut, StateReplaced, StateLoggingIn:
+			return
+		}
+
+		s.mu.Lock()
+		s.status.Reconnects++
+		s.mu.Unlock()
+		s.setState(StateConnecting, nil)
+
+		err := s.dial(ctx, s.Client())
+		if err == nil {
+			return
+		}
+
+		s.setState(StateDisconnected, err)
+		s.logf("Reconnect attempt %d failed: %v, retrying in %s", attempt, err, delay)
+		if !s.wait(ctx, delay) {
+			return
+		}
+		delay = min(delay*2, s.MaxBackoff)
+	}
+}
+
+// dialClient connects client and waits until it is logged in.
+func dialClient(ctx context.Context, client *whatsmeow.Client) error {
+	err := client.Connect()
+	if err != nil && !errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+		return err
+	}
+	if client.WaitForConnection(30 * time.Second) {
+		return nil
+	}
+	client.Disconnect()
+	return errors.New("timed out waiting for login")
+}
+
+// relogin wipes whatever is left of the old session and pairs a fresh device.
+func (s *ConnectionSupervisor) relogin(ctx context.Context) {
+	old := s.Client()
+	old.Disconnect()
+	if old.Store.ID != nil {
+		if err := old.Store.Delete(ctx); err != nil {
+			s.logf("Failed to delete old session: %v", err)
+		}
+	}
+
+	delay := s.MinBackoff
+	for {
+		client, err := s.newClient(old)
+		if err != nil {
+			s.setState(StateLoggedOut, err)
+			return
+		}
+		client.EnableAutoReconnect = false
+		client.AddEventHandler(s.handler)
+
+		s.mu.Lock()
+		s.client = client
+		s.mu.Unlock()
+
+		s.setState(StateLoggingIn, nil)
+		s.logf("Session was logged out, waiting for a new login...")
+		err = s.pair(ctx, client)
+		if err == nil {
+			return
+		}
+
+		client.RemoveEventHandlers()
+		s.setState(StateLoggedOut, err)
+		s.logf("Login failed: %v, retrying in %s", err, delay)
+		if !s.wait(ctx, delay) {
+			return
+		}
+		delay = min(delay*2, s.MaxBackoff)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// supervisorRig drives a ConnectionSupervisor with scripted dial and pair results
+// and records the backoff waits instead of sleeping through them.
+type supervisorRig struct {
+	t   *testing.T
+	sup *ConnectionSupervisor
+
+	mu      sync.Mutex
+	dials   []error // results of the next dials, nil once used up
+	pairs   []error
+	paired  int // pair calls so far
+	waits   []time.Duration
+	clients []*whatsmeow.Client // clients made by re-logins
+}
+
+func newSupervisorRig(t *testing.T) *supervisorRig {
+	t.Helper()
+	ctx := context.Background()
+	container, err := openSessionStore(ctx, "file:"+t.Name()+"?mode=memory&cache=shared&_foreign_keys=on", "ERROR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = container.Close() })
+
+	rig := &supervisorRig{t: t}
+	sup := NewConnectionSupervisor(newClient(container.NewDevice(), "ERROR"), LoginOptions{}, "ERROR", func(any) {})
+	sup.dial = func(ctx context.Context, client *whatsmeow.Client) error {
+		return rig.next(&rig.dials)
+	}
+	sup.pair = func(ctx context.Context, client *whatsmeow.Client) error {
+		rig.mu.Lock()
+		rig.paired++
+		rig.mu.Unlock()
+		return rig.next(&rig.pairs)
+	}
+	sup.newClient = func(old *whatsmeow.Client) (*whatsmeow.Client, error) {
+		client := newClient(container.NewDevice(), "ERROR")
+		rig.mu.Lock()
+		rig.clients = append(rig.clients, client)
+		rig.mu.Unlock()
+		return client, nil
+	}
+	sup.wait = func(ctx context.Context, d time.Duration) bool {
+		rig.mu.Lock()
+		rig.waits = append(rig.waits, d)
+		rig.mu.Unlock()
+		return ctx.Err() == nil
+	}
+	rig.sup = sup
+	return rig
+}
+
+func (r *supervisorRig) next(results *[]error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(*results) == 0 {
+		return nil
+	}
+	err := (*results)[0]
+	*results = (*results)[1:]
+	return err
+}
+
+// Waits returns the backoff delays seen so far and clears them.
+func (r *supervisorRig) Waits() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.waits
+	r.waits = nil
+	return out
+}
+
+func TestSupervisorBackoffResetsAfterReconnect(t *testing.T) {
+	rig := newSupervisorRig(t)
+	sup := rig.sup
+	sup.MinBackoff = time.Second
+	sup.MaxBackoff = 3 * time.Second
+	fail := errors.New("no network")
+
+	rig.dials = []error{fail, fail, fail, fail}
+	sup.HandleEvent(&events.Disconnected{})
+	if sup.State() != StateDisconnected {
+		t.Fatalf("expected disconnected, got %s", sup.State())
+	}
+	sup.reconnect(context.Background())
+	if got, want := rig.Waits(), []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}; !slices.Equal(got, want) {
+		t.Fatalf("backoff: got %v, want %v", got, want)
+	}
+	sup.HandleEvent(&events.Connected{})
+	if status := sup.Status(); status.State != StateConnected || status.Reconnects != 5 || !errors.Is(status.LastError, fail) {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// The next outage starts from the minimum again.
+	rig.dials = []error{fail}
+	sup.HandleEvent(&events.Disconnected{})
+	sup.reconnect(context.Background())
+	if got := rig.Waits(); !slices.Equal(got, []time.Duration{time.Second}) {
+		t.Fatalf("backoff didn't reset: %v", got)
+	}
+
+	// Connected, logged out or replaced sessions aren't redialled.
+	sup.HandleEvent(&events.StreamReplaced{})
+	sup.reconnect(context.Background())
+	if status := sup.Status(); status.State != StateReplaced || status.Reconnects != 7 {
+		t.Fatalf("reconnected a replaced session: %+v", status)
+	}
+}
+
+func TestSupervisorReloginsAfterLoggedOut(t *testing.T) {
+	rig := newSupervisorRig(t)
+	sup := rig.sup
+	old := sup.Client()
+	rig.pairs = []error{errors.New("qr timed out")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		sup.Run(ctx)
+		close(done)
+	}()
+
+	sup.HandleEvent(&events.LoggedOut{})
+	if sup.State() != StateLoggedOut {
+		t.Fatalf("expected logged out, got %s", sup.State())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rig.mu.Lock()
+		paired := rig.paired
+		rig.mu.Unlock()
+		if paired == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("relogin didn't finish, state %s", sup.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	rig.mu.Lock()
+	clients := rig.clients
+	rig.mu.Unlock()
+	if len(clients) != 2 {
+		t.Fatalf("expected a fresh device per login attempt, got %d", len(clients))
+	}
+	if sup.Client() == old || sup.Client() != clients[1] {
+		t.Errorf("supervisor didn't switch to the new client")
+	}
+	if got := rig.Waits(); !slices.Equal(got, []time.Duration{sup.MinBackoff}) {
+		t.Errorf("expected one backoff after the failed login, got %v", got)
+	}
+
+	// Disconnects while a login is on screen are left to the login flow.
+	sup.HandleEvent(&events.Disconnected{})
+	if sup.State() != StateLoggingIn {
+		t.Errorf("disconnect during login changed the state to %s", sup.State())
+	}
+}
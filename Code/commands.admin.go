@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Features the owner can turn off with --disable.
+const (
+	featureSummaries = "summaries"
+	featureChat      = "chat"
+	featureStickers  = "stickers"
+	featureAudio     = "audio"
+)
+
+var toggleableFeatures = []string{featureSummaries, featureChat, featureStickers, featureAudio}
+
+const adminHelp = `*Owner commands*
+--groups: groups I'm in
+--whitelist <group>: let me work in a group
+--unwhitelist <group>: stop working in a group
+--approve [group] / --deny [group]: answer a pending invite
+--stats: uptime, groups and LLM usage
+--reload: reload config.json and prompts.json
+--broadcast <text>: send text to every whitelisted group
+--features, --enable <feature>, --disable <feature>
+
+<group> is a name, part of one, or an ID.`
+
+// featureEnabled reports whether feature is on. Errors count as on, so a
+// database hiccup doesn't silence the bot.
+func (b *Bot) featureEnabled(feature string) bool {
+	disabled, err := isFeatureDisabledCached(b.FeatureCache, feature, b.DB)
+	if err != nil {
+		fmt.Printf("Failed to check feature %s: %v\n", feature, err)
+		return true
+	}
+	return !disabled
+}
+
+// handleOwnerDM runs the commands the owner can send the bot in a direct message.
+// The ones that wait on WhatsApp run in the background, like summaries, so they
+// don't hold up the event handler.
+func (b *Bot) handleOwnerDM(ctx *MessageContext) {
+	if ctx.Timestamp.Before(b.StartTime) {
+		return
+	}
+	words := strings.Fields(ctx.Text)
+	if len(words) == 0 {
+		return
+	}
+	rootCtx := b.Shutdown.Context()
+
+	switch strings.ToLower(words[0]) {
+	case "--approve":
+		b.handleApproveCommand(ctx, words, true)
+	case "--deny":
+		b.handleApproveCommand(ctx, words, false)
+
+	// ? ===================================
+	case "--groups":
+		b.Shutdown.Go(func(jobCtx context.Context) {
+			b.handleGroupsCommand(jobCtx, ctx)
+		})
+	case "--whitelist":
+		b.handleWhitelistCommand(rootCtx, ctx, words, true)
+	case "--unwhitelist":
+		b.handleWhitelistCommand(rootCtx, ctx, words, false)
+
+	// ? ===================================
+	case "--stats":
+		b.handleStatsCommand(rootCtx, ctx)
+	case "--reload", "--reload-json":
+		if err := b.ReloadConfigs(); err != nil {
+			b.Messenger.SendReplyMessage(ctx, "Failed to reload configs: "+err.Error())
+		} else {
+			b.Messenger.SendReplyMessage(ctx, "Configs reloaded successfully.")
+		}
+	case "--broadcast":
+		text := strings.TrimSpace(ctx.Text[len(words[0]):])
+		b.Shutdown.Go(func(jobCtx context.Context) {
+			b.handleBroadcastCommand(jobCtx, ctx, text)
+		})
+
+	// ? ===================================
+	case "--features":
+		b.Messenger.SendReplyMessage(ctx, b.featureList())
+	case "--enable":
+		b.handleFeatureCommand(ctx, words, true)
+	case "--disable":
+		b.handleFeatureCommand(ctx, words, false)
+
+	default:
+		b.Messenger.SendReplyMessage(ctx, adminHelp)
+	}
+}
+
+// ? ----------------------------------------------Groups----------------------------------------------
+
+// handleGroupsCommand lists the groups the bot is in, refreshing the stored
+// ones from WhatsApp when it can.
+func (b *Bot) handleGroupsCommand(rootCtx context.Context, ctx *MessageContext) {
+	note := ""
+	if joined, err := b.Messenger.JoinedGroups(); err != nil {
+		fmt.Printf("Failed to fetch joined groups: %v\n", err)
+		note = "\n\n_Couldn't reach WhatsApp, this is what I last saw._"
+	} else {
+		now := time.Now()
+		current := make(map[string]bool)
+		for _, info := range joined {
+			current[info.JID.String()] = true
+			if err := b.DB.SaveGroup(rootCtx, b.groupMetaFromInfo(info, now)); err != nil {
+				fmt.Printf("Failed to save group %s: %v\n", info.JID, err)
+			}
+		}
+		// Groups left while the bot was offline.
+		if stored, err := b.DB.ListGroups(rootCtx); err == nil {
+			for _, group := range stored {
+				if current[group.ChatJID] {
+					continue
+				}
+				if err := b.DB.DeleteGroup(rootCtx, group.ChatJID); err != nil {
+					fmt.Printf("Failed to forget group %s: %v\n", group.ChatJID, err)
+				}
+			}
+		}
+	}
+
+	groups, err := b.DB.ListGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to list groups: %v\n", err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to load groups.")
+		return
+	}
+	if len(groups) == 0 {
+		b.Messenger.SendReplyMessage(ctx, "I'm not in any groups."+note)
+		return
+	}
+	pending := b.pendingGroupSet(rootCtx)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*Groups (%d)*\n", len(groups)))
+	for _, group := range groups {
+		jid, _ := types.ParseJID(group.ChatJID)
+		state := "not whitelisted"
+		if allowed, _ := b.isGroupAllowed(rootCtx, jid); allowed {
+			state = "whitelisted"
+		} else if pending[group.ChatJID] {
+			state = "pending"
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%s): %s\n", groupLabel(group.Name, jid), jid.User, state))
+	}
+	b.Messenger.SendReplyMessage(ctx, strings.TrimRight(sb.String(), "\n")+note)
+}
+
+// handleWhitelistCommand whitelists a group by name or ID, or takes it off the list.
+func (b *Bot) handleWhitelistCommand(rootCtx context.Context, ctx *MessageContext, words []string, add bool) {
+	if len(words) < 2 {
+		b.Messenger.SendReplyMessage(ctx, "Usage: "+words[0]+" <group name or ID>")
+		return
+	}
+	group, reply := b.resolveGroup(rootCtx, strings.Join(words[1:], " "))
+	if group == nil {
+		b.Messenger.SendReplyMessage(ctx, reply)
+		return
+	}
+	chat, _ := types.ParseJID(group.ChatJID)
+	label := groupLabel(group.Name, chat)
+
+	if add {
+		if err := b.DB.AddGroupToWhitelist(rootCtx, group.ChatJID); err != nil {
+			fmt.Printf("Failed to whitelist %s: %v\n", group.ChatJID, err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to whitelist the group.")
+			return
+		}
+		// Whitelisting answers a pending invite too.
+		if _, err := b.DB.RemovePendingGroup(rootCtx, group.ChatJID); err != nil {
+			fmt.Printf("Failed to clear pending group %s: %v\n", group.ChatJID, err)
+		}
+		b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Whitelisted *%s*.", label))
+		return
+	}
+
+	if err := b.DB.RemoveGroupFromWhitelist(rootCtx, group.ChatJID); err != nil {
+		fmt.Printf("Failed to unwhitelist %s: %v\n", group.ChatJID, err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to unwhitelist the group.")
+		return
+	}
+	if slices.Contains(b.Config().GroupWhitelist, group.ChatJID) {
+		b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Removed *%s*, but it's still whitelisted in the config file.", label))
+		return
+	}
+	b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Unwhitelisted *%s*.", label))
+}
+
+// resolveGroup finds a stored group by ID, exact name, or a piece of its name
+// that only one group has. Without a match it returns nil and what to tell the owner.
+func (b *Bot) resolveGroup(rootCtx context.Context, query string) (*GroupMeta, string) {
+	groups, err := b.DB.ListGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to list groups: %v\n", err)
+		return nil, "Failed to load groups."
+	}
+
+	query = strings.TrimSpace(query)
+	lower := strings.ToLower(query)
+	var partial []GroupMeta
+	for i, group := range groups {
+		jid, _ := types.ParseJID(group.ChatJID)
+		if query == group.ChatJID || query == jid.User || strings.EqualFold(query, group.Name) {
+			return &groups[i], ""
+		}
+		if strings.Contains(strings.ToLower(group.Name), lower) {
+			partial = append(partial, group)
+		}
+	}
+
+	switch len(partial) {
+	case 0:
+		return nil, fmt.Sprintf("No group matches %q. Send --groups to see them.", query)
+	case 1:
+		return &partial[0], ""
+	}
+	names := make([]string, len(partial))
+	for i, group := range partial {
+		names[i] = group.Name
+	}
+	return nil, fmt.Sprintf("%q matches %d groups: %s", query, len(partial), strings.Join(names, ", "))
+}
+
+// pendingGroupSet returns the chat JIDs waiting for approval.
+func (b *Bot) pendingGroupSet(rootCtx context.Context) map[string]bool {
+	set := make(map[string]bool)
+	pending, err := b.DB.ListPendingGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to load pending groups: %v\n", err)
+	}
+	for _, group := range pending {
+		set[group.ChatJID] = true
+	}
+	return set
+}
+
+// whitelistedGroups merges the config and database whitelists.
+func (b *Bot) whitelistedGroups(rootCtx context.Context) ([]string, error) {
+	stored, err := b.DB.ListWhitelistedGroups(rootCtx)
+	if err != nil {
+		return nil, err
+	}
+	out := slices.Clone(b.Config().GroupWhitelist)
+	for _, chat := range stored {
+		if !slices.Contains(out, chat) {
+			out = append(out, chat)
+		}
+	}
+	return out, nil
+}
+
+// ? ----------------------------------------------Stats & Broadcast----------------------------------------------
+
+func (b *Bot) handleStatsCommand(rootCtx context.Context, ctx *MessageContext) {
+	groups, err := b.DB.ListGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to list groups: %v\n", err)
+	}
+	whitelisted, err := b.whitelistedGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to list whitelisted groups: %v\n", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*Uptime:* %s\n*Connection:* %s\n", time.Since(b.StartTime).Round(time.Second), b.Supervisor.Status()))
+	sb.WriteString(fmt.Sprintf("*Groups:* %d known, %d whitelisted, %d pending\n\n", len(groups), len(whitelisted), len(b.pendingGroupSet(rootCtx))))
+
+	report, err := b.usageReport(rootCtx, ctx, true)
+	if err != nil {
+		fmt.Printf("Failed to build usage report: %v\n", err)
+		report = "Failed to load usage."
+	}
+	sb.WriteString(report)
+	b.Messenger.SendReplyMessage(ctx, sb.String())
+}
+
+// handleBroadcastCommand sends text to every whitelisted group.
+func (b *Bot) handleBroadcastCommand(rootCtx context.Context, ctx *MessageContext, text string) {
+	if text == "" {
+		b.Messenger.SendReplyMessage(ctx, "Usage: --broadcast <text>")
+		return
+	}
+	chats, err := b.whitelistedGroups(rootCtx)
+	if err != nil {
+		fmt.Printf("Failed to list whitelisted groups: %v\n", err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to load groups.")
+		return
+	}
+
+	sent, failed := 0, 0
+	for _, chat := range chats {
+		jid, err := types.ParseJID(chat)
+		if err == nil {
+			err = b.Messenger.SendTextMessage(jid, text)
+		}
+		if err != nil {
+			fmt.Printf("Failed to broadcast to %s: %v\n", chat, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+
+	reply := fmt.Sprintf("Sent to %d groups.", sent)
+	if failed > 0 {
+		reply += fmt.Sprintf(" %d failed.", failed)
+	}
+	b.Messenger.SendReplyMessage(ctx, reply)
+}
+
+// ? ----------------------------------------------Features----------------------------------------------
+
+func (b *Bot) handleFeatureCommand(ctx *MessageContext, words []string, enable bool) {
+	if len(words) < 2 || !slices.Contains(toggleableFeatures, strings.ToLower(words[1])) {
+		b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Usage: %s <%s>", words[0], strings.Join(toggleableFeatures, "|")))
+		return
+	}
+	feature := strings.ToLower(words[1])
+
+	if err := setFeatureDisabledCache(b.FeatureCache, feature, !enable, b.DB); err != nil {
+		fmt.Printf("Failed to toggle %s: %v\n", feature, err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to change the feature.")
+		return
+	}
+	state := "off"
+	if enable {
+		state = "on"
+	}
+	b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Turned %s %s.", feature, state))
+}
+
+func (b *Bot) featureList() string {
+	var sb strings.Builder
+	sb.WriteString("*Features*")
+	for _, feature := range toggleableFeatures {
+		state := "on"
+		if !b.featureEnabled(feature) {
+			state = "off"
+		}
+		sb.WriteString(fmt.Sprintf("\n- %s: %s", feature, state))
+	}
+	return sb.String()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// globalAliasChat is the chat_jid under which global aliases are stored.
+	// They apply in every chat where the person has no alias of their own.
+	globalAliasChat = "*"
+	maxAliasLength  = 40
+)
+
+const aliasUsage = "Usage:\n- --alias <name>\n- --alias show\n- --alias clear [global]\n- --alias global <name>\n- --alias set @user <name> (admins)\n- --aliases"
+
+// aliasName joins the words of a new alias and checks it.
+func aliasName(words []string) (string, error) {
+	name := strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+	if name == "" {
+		return "", errors.New(aliasUsage)
+	}
+	if utf8.RuneCountInString(name) > maxAliasLength {
+		return "", fmt.Errorf("Aliases can be at most %d characters.", maxAliasLength)
+	}
+	return name, nil
+}
+
+// handleAliasCommand sets, shows or clears the sender's alias, their global
+// alias, or (for group admins and the owner) someone else's alias.
+func (b *Bot) handleAliasCommand(ctx *MessageContext, words []string) {
+	if b.DB == nil {
+		b.Messenger.SendTextMessage(ctx.ChatID, "Database not initialized.")
+		return
+	}
+	if len(words) < 2 {
+		b.Messenger.SendReplyMessage(ctx, aliasUsage)
+		return
+	}
+
+	chatJID := ctx.ChatID.String()
+	senderJID := b.senderJID(ctx)
+
+	switch strings.ToLower(words[1]) {
+	case "show":
+		b.showAlias(ctx, senderJID)
+
+	case "clear":
+		scope, what := chatJID, "Your alias in this chat"
+		if len(words) > 2 && strings.ToLower(words[2]) == "global" {
+			scope, what = globalAliasChat, "Your global alias"
+		}
+		removed, err := clearAliasCache(b.AliasCache, scope, senderJID, b.DB)
+		if err != nil {
+			fmt.Printf("Failed to clear alias: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to clear alias")
+			return
+		}
+		if !removed {
+			b.Messenger.SendReplyMessage(ctx, "You don't have an alias to clear.")
+			return
+		}
+		b.Messenger.SendReplyMessage(ctx, what+" has been cleared.")
+
+	case "global":
+		b.saveAlias(ctx, globalAliasChat, senderJID, words[2:], "Global alias has been saved.")
+
+	case "set":
+		if len(ctx.Mentions) != 1 || len(words) < 4 {
+			b.Messenger.SendReplyMessage(ctx, "Usage: --alias set @user <name>")
+			return
+		}
+		if !b.isOwner(ctx.SenderID) && !b.isGroupAdmin(context.Background(), ctx.ChatID, ctx.SenderID) {
+			b.Messenger.SendReplyMessage(ctx, "Only group admins can set other people's aliases.")
+			return
+		}
+		target, ok := parseNormalizedJID(ctx.Mentions[0])
+		if !ok {
+			b.Messenger.SendReplyMessage(ctx, "Usage: --alias set @user <name>")
+			return
+		}
+		b.saveAlias(ctx, chatJID, b.canonicalJID(target).String(), words[3:], "Alias has been saved.")
+
+	default:
+		b.saveAlias(ctx, chatJID, senderJID, words[1:], "Alias has been saved.")
+	}
+}
+
+func (b *Bot) saveAlias(ctx *MessageContext, chatJID, senderJID string, words []string, confirmation string) {
+	alias, err := aliasName(words)
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, err.Error())
+		return
+	}
+	if err := setNewAliasCache(b.AliasCache, chatJID, senderJID, alias, b.DB); err != nil {
+		fmt.Printf("Failed to save alias: %v\n", err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to save alias")
+		return
+	}
+	b.Messenger.SendReplyMessage(ctx, confirmation)
+}
+
+func (b *Bot) showAlias(ctx *MessageContext, senderJID string) {
+	local, err := cachedAlias(b.AliasCache, ctx.ChatID.String(), senderJID, b.DB)
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, "Failed to load alias")
+		return
+	}
+	global, err := cachedAlias(b.AliasCache, globalAliasChat, senderJID, b.DB)
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, "Failed to load alias")
+		return
+	}
+
+	var lines []string
+	switch {
+	case local != "":
+		lines = append(lines, "Your alias in this chat is *"+local+"*.")
+	case global != "":
+		lines = append(lines, "You don't have an alias in this chat, so your global alias is used.")
+	default:
+		lines = append(lines, "You don't have an alias in this chat.")
+	}
+	if global != "" {
+		lines = append(lines, "Your global alias is *"+global+"*.")
+	}
+	b.Messenger.SendReplyMessage(ctx, strings.Join(lines, "\n"))
+}
+
+// handleAliasesCommand lists the aliases set in this chat.
+func (b *Bot) handleAliasesCommand(ctx *MessageContext) {
+	entries, err := b.DB.ListAliases(context.Background(), ctx.ChatID.String())
+	if err != nil {
+		fmt.Printf("Failed to list aliases: %v\n", err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to load aliases")
+		return
+	}
+	if len(entries) == 0 {
+		b.Messenger.SendReplyMessage(ctx, "Nobody has an alias in this chat yet.")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*Aliases in this chat*")
+	for _, entry := range entries {
+		user := entry.SenderJID
+		if jid, err := types.ParseJID(entry.SenderJID); err == nil {
+			user = jid.User
+		}
+		sb.WriteString(fmt.Sprintf("\n- %s (%s)", entry.Alias, user))
+	}
+	b.Messenger.SendReplyMessage(ctx, sb.String())
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSummaryCount = 50
+	maxSummaryCount     = 1000
+)
+
+// parseSummarizeArgs reads "--summarize [N] [<time> | --since <time> | --since-me] [--short|--medium|--long]
+// [--style <name>] [--reason]". Times are read relative to now, see parseSinceExpr.
+func parseSummarizeArgs(words []string, prompts *PromptsConfig, now time.Time) (*SummaryInfo, error) {
+	info := &SummaryInfo{
+		MessageCount: defaultSummaryCount,
+		Length:       "medium",
+		Style:        prompts.DefaultStyle(),
+	}
+	countGiven := false
+
+	for i := 1; i < len(words); i++ {
+		word := strings.ToLower(strings.TrimSpace(words[i]))
+		switch {
+		case word == "":
+			continue
+		case word == "--since-me":
+			info.SinceMe = true
+		case word == "--since":
+			since, used, err := parseSinceExpr(words[i+1:], now)
+			if err != nil {
+				return nil, fmt.Errorf("--since: %v", err)
+			}
+			info.Since = since
+			i += used
+		case word == "--short", word == "--medium", word == "--long":
+			info.Length = strings.TrimPrefix(word, "--")
+		case word == "--reason":
+			info.Reason = true
+		case word == "--style", strings.HasPrefix(word, "--style="):
+			name := strings.TrimPrefix(word, "--style=")
+			if word == "--style" {
+				if i+1 >= len(words) {
+					return nil, fmt.Errorf("--style needs a name: %s", strings.Join(prompts.StyleNames(), ", "))
+				}
+				i++
+				name = strings.ToLower(strings.TrimSpace(words[i]))
+			}
+			if _, ok := prompts.SummaryStyles[name]; !ok {
+				return nil, fmt.Errorf("unknown style %q, try: %s", name, strings.Join(prompts.StyleNames(), ", "))
+			}
+			info.Style = name
+		default:
+			// Plain numbers are a message count, anything else may be a time.
+			if n, err := strconv.Atoi(word); err == nil {
+				if n <= 0 {
+					return nil, fmt.Errorf("unknown option %q", words[i])
+				}
+				info.MessageCount = min(n, maxSummaryCount)
+				countGiven = true
+				continue
+			}
+			since, used, err := parseSinceExpr(words[i:], now)
+			if err != nil {
+				return nil, fmt.Errorf("unknown option %q", words[i])
+			}
+			info.Since = since
+			i += used - 1
+		}
+	}
+
+	if info.SinceMe && !info.Since.IsZero() {
+		return nil, errors.New("use either --since-me or a time, not both")
+	}
+	if !info.Since.IsZero() && info.Since.After(now) {
+		return nil, errors.New("that time is in the future")
+	}
+	// A time range replaces the default count; an explicit N still caps it.
+	if (info.SinceMe || !info.Since.IsZero()) && !countGiven {
+		info.MessageCount = maxSummaryCount
+	}
+
+	return info, nil
+}
+
+// buildSummaryPrompt assembles the chat messages sent to the model. partial means
+// the transcript is a list of summaries of consecutive parts rather than the chat itself.
+func buildSummaryPrompt(prompts *PromptsConfig, info *SummaryInfo, transcript string, partial bool) []ChatMessage {
+	var instructions []string
+	if style := prompts.SummaryStyles[info.Style]; style != "" {
+		instructions = append(instructions, style)
+	}
+	if length := prompts.LengthPrompt(info.Length); length != "" {
+		instructions = append(instructions, length)
+	}
+	where := ""
+	if info.GroupName != "" {
+		where = fmt.Sprintf(" in the group %q", info.GroupName)
+	}
+	if partial {
+		instructions = append(instructions, "The conversation"+where+" was too long to read at once, so here are summaries of its parts in order:\n"+transcript)
+	} else {
+		instructions = append(instructions, "Conversation"+where+":\n"+transcript)
+	}
+
+	return []ChatMessage{
+		{Role: "system", Content: prompts.PersonalityPrompt},
+		{Role: "user", Content: strings.Join(instructions, "\n\n")},
+	}
+}
+
+// handleSummarizeCommand replies with a summary of the chat's recent messages.
+// The model call runs as a tracked background job so it doesn't block the event queue.
+func (b *Bot) handleSummarizeCommand(ctx *MessageContext, words []string) {
+	prompts := b.Prompts()
+	info, err := parseSummarizeArgs(words, prompts, ctx.Timestamp)
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, err.Error())
+		return
+	}
+	if b.LLM == nil {
+		b.Messenger.SendReplyMessage(ctx, "Summaries are not configured.")
+		return
+	}
+
+	b.Shutdown.Go(func(jobCtx context.Context) {
+		work := b.startWork(ctx)
+		succeeded := false
+		defer func() { work.Done(succeeded) }()
+
+		since := info.Since
+		if info.SinceMe {
+			last, ok, err := b.DB.LastMessageTime(jobCtx, ctx.ChatID.String(), b.senderJID(ctx), ctx.Timestamp)
+			if err != nil {
+				fmt.Printf("Failed to find sender's last message: %v\n", err)
+				b.Messenger.SendReplyMessage(ctx, "Failed to load messages.")
+				return
+			}
+			if !ok {
+				b.Messenger.SendReplyMessage(ctx, "I don't have any earlier messages from you in this chat.")
+				return
+			}
+			since = last
+		}
+
+		messages, err := b.DB.ListMessageContextSince(jobCtx, ctx.ChatID.String(), since, info.MessageCount)
+		if err != nil {
+			fmt.Printf("Failed to load messages to summarize: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to load messages.")
+			return
+		}
+		if info.SinceMe {
+			messages = dropMessagesFrom(messages, b.senderJID(ctx))
+		}
+		if len(messages) == 0 {
+			if since.IsZero() {
+				b.Messenger.SendReplyMessage(ctx, "Nothing to summarize yet.")
+			} else {
+				b.Messenger.SendReplyMessage(ctx, "Nothing was said since "+since.Format("2006-01-02 15:04")+".")
+			}
+			return
+		}
+
+		info.GroupName = b.groupName(jobCtx, ctx.ChatID)
+
+		model := deepSeekChatModel
+		if info.Reason {
+			model = deepSeekReasonModel
+		}
+		config := b.Config()
+		summarizer := &Summarizer{
+			LLM:          b.llm(),
+			Prompts:      prompts,
+			Model:        model,
+			Limits:       config.ModelLimits(model),
+			ChunkModel:   deepSeekChatModel,
+			ChunkLimits:  config.ModelLimits(deepSeekChatModel),
+			MaxCost:      config.MaxSummaryCost(),
+			ChatJID:      ctx.ChatID.String(),
+			RequesterJID: b.senderJID(ctx),
+		}
+
+		lines := transcriptLines(messages)
+		estimate := summarizer.EstimateCost(info, lines)
+		if estimate > summarizer.MaxCost {
+			b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("That would cost about $%.3f, over the $%.3f limit. Try fewer messages or a shorter time range.", estimate, summarizer.MaxCost))
+			return
+		}
+
+		quota := QuotaRequest{User: b.canonicalJID(ctx.SenderID), Chat: ctx.ChatID, Reason: info.Reason, EstimatedCost: estimate}
+		denial, err := b.checkQuota(jobCtx, quota, time.Now())
+		if err != nil {
+			fmt.Printf("Failed to check quota: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to summarize, try again later.")
+			return
+		}
+		if denial != "" {
+			b.Messenger.SendReplyMessage(ctx, denial)
+			return
+		}
+
+		placeholder, err := b.Messenger.SendReplyMessageID(ctx, summaryPlaceholder)
+		if err != nil {
+			fmt.Printf("Failed to send summary placeholder: %v\n", err)
+		}
+		if placeholder != "" {
+			summarizer.OnChunk = func(done, total int) {
+				_ = b.Messenger.EditMessage(ctx.ChatID, placeholder, fmt.Sprintf("%s (%d/%d parts read)", summaryPlaceholder, done, total))
+			}
+		}
+
+		start := time.Now()
+		result, err := summarizer.Summarize(jobCtx, info, lines)
+		// Whatever was spent counts, even if the summary didn't finish.
+		if err := b.recordQuota(jobCtx, quota, summarizer.Tokens, summarizer.Spent, time.Now()); err != nil {
+			fmt.Printf("Failed to record quota usage: %v\n", err)
+		}
+		// Nothing was spent, so the attempt doesn't count against the rate limit.
+		if err != nil && summarizer.Tokens == 0 {
+			if err := b.refundQuota(jobCtx, quota); err != nil {
+				fmt.Printf("Failed to refund quota: %v\n", err)
+			}
+		}
+		if errors.Is(err, errCostLimit) {
+			if err := b.sendFormattedReply(ctx, placeholder, "Stopped before going over the cost limit. Try fewer messages or a shorter time range."); err != nil {
+				fmt.Printf("Failed to send reply: %v\n", err)
+			}
+			return
+		}
+		if err != nil {
+			fmt.Printf("Summary failed: %v\n", err)
+			if err := b.sendFormattedReply(ctx, placeholder, "Failed to summarize, try again later."); err != nil {
+				fmt.Printf("Failed to send reply: %v\n", err)
+			}
+			return
+		}
+		fmt.Printf("Summarized %d messages (%s, %s) in %s: %d calls, %d levels, $%.4f\n",
+			len(messages), info.Style, info.Length, time.Since(start).Round(time.Millisecond), result.Calls, result.Levels, result.Cost)
+
+		if err := b.sendFormattedReply(ctx, placeholder, result.Content); err != nil {
+			fmt.Printf("Failed to send summary: %v\n", err)
+			return
+		}
+		succeeded = true
+	})
+}
+
+// dropMessagesFrom removes the asker's own messages, which is what --since-me
+// starts from; they already know what they said.
+func dropMessagesFrom(messages []StoredMessage, senderJID string) []StoredMessage {
+	out := messages[:0]
+	for _, msg := range messages {
+		if msg.SenderJID != senderJID {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+// ? ----------------------------------------------Prompt Helpers----------------------------------------------
+
+// DefaultStyle returns the configured default style, falling back to "bullets".
+func (pc *PromptsConfig) DefaultStyle() string {
+	if _, ok := pc.SummaryStyles[pc.DefaultSummaryStyle]; ok {
+		return pc.DefaultSummaryStyle
+	}
+	if _, ok := pc.SummaryStyles["bullets"]; ok {
+		return "bullets"
+	}
+	return ""
+}
+
+// StyleNames lists the configured summary styles alphabetically.
+func (pc *PromptsConfig) StyleNames() []string {
+	names := make([]string, 0, len(pc.SummaryStyles))
+	for name := range pc.SummaryStyles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LengthPrompt maps "short", "medium" or "long" to its prompt.
+func (pc *PromptsConfig) LengthPrompt(length string) string {
+	switch length {
+	case "short":
+		return pc.LengthShort
+	case "long":
+		return pc.LengthLong
+	default:
+		return pc.LengthMedium
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const usageTopN = 5
+
+// usagePeriod is one row of the --usage report.
+type usagePeriod struct {
+	Name  string
+	Since time.Time
+}
+
+// usagePeriods returns today, this week (from Monday) and this month, in now's time zone.
+func usagePeriods(now time.Time) []usagePeriod {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	weekday := (int(today.Weekday()) + 6) % 7 // Monday = 0
+	return []usagePeriod{
+		{Name: "Today", Since: today},
+		{Name: "This week", Since: today.AddDate(0, 0, -weekday)},
+		{Name: "This month", Since: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())},
+	}
+}
+
+// formatTokens shortens token counts like 12345 to "12.3k".
+func formatTokens(n int) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+func formatUsage(total UsageTotal) string {
+	calls := "calls"
+	if total.Calls == 1 {
+		calls = "call"
+	}
+	return fmt.Sprintf("%d %s, %s tokens, $%.3f", total.Calls, calls, formatTokens(total.Tokens), total.Cost)
+}
+
+// handleUsageCommand reports LLM spend for this chat, broken down by person
+// for each period.
+// The owner can add "all" to see every chat the bot is in.
+func (b *Bot) handleUsageCommand(ctx *MessageContext, words []string) {
+	all := len(words) > 1 && strings.ToLower(words[1]) == "all"
+	if all && !b.isOwner(ctx.SenderID) {
+		b.Messenger.SendReplyMessage(ctx, "Only the owner can see usage across all chats.")
+		return
+	}
+
+	report, err := b.usageReport(b.Shutdown.Context(), ctx, all)
+	if err != nil {
+		fmt.Printf("Failed to build usage report: %v\n", err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to load usage.")
+		return
+	}
+	b.Messenger.SendReplyMessage(ctx, report)
+}
+
+func (b *Bot) usageReport(rootCtx context.Context, ctx *MessageContext, all bool) (string, error) {
+	periods := usagePeriods(ctx.Timestamp)
+	month := periods[len(periods)-1]
+
+	chatJID := ctx.ChatID.String()
+	title := "*Usage in this chat*"
+	if all {
+		chatJID = ""
+		title = "*Usage across all chats*"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(title + "\n")
+	for _, period := range periods {
+		totals, err := b.DB.UsageTotals(rootCtx, "", chatJID, period.Since, 0)
+		if err != nil {
+			return "", err
+		}
+		var total UsageTotal
+		if len(totals) > 0 {
+			total = totals[0]
+		}
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", period.Name, formatUsage(total)))
+	}
+
+	if all {
+		chats, err := b.DB.UsageTotals(rootCtx, "chat_jid", "", month.Since, usageTopN)
+		if err != nil {
+			return "", err
+		}
+		if len(chats) > 0 {
+			sb.WriteString("\n*Top chats this month*\n")
+			for _, chat := range chats {
+				sb.WriteString(fmt.Sprintf("- %s: %s\n", b.usageChatName(rootCtx, chat.Key), formatUsage(chat)))
+			}
+		}
+	}
+
+	for _, period := range periods {
+		users, err := b.DB.UsageTotals(rootCtx, "requester_jid", chatJID, period.Since, usageTopN)
+		if err != nil {
+			return "", err
+		}
+		if len(users) == 0 {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\n*Top users %s*\n", strings.ToLower(period.Name)))
+		for _, user := range users {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", b.usageName(rootCtx, ctx.ChatID, user.Key), formatUsage(user)))
+		}
+	}
+
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// usageChatName shows a chat by its group name, or a DM by the other person's name.
+func (b *Bot) usageChatName(rootCtx context.Context, chat string) string {
+	jid, err := types.ParseJID(chat)
+	if err != nil {
+		return chat
+	}
+	if jid.Server == types.GroupServer {
+		return groupLabel(b.groupName(rootCtx, jid), jid)
+	}
+	return b.displayName(jid, "")
+}
+
+// usageName shows a requester by their alias in chat, falling back to their contact name or number.
+func (b *Bot) usageName(rootCtx context.Context, chat types.JID, requester string) string {
+	if alias, err := isAliasCached(b.AliasCache, chat.String(), requester, b.DB); err == nil && alias != "" {
+		return alias
+	}
+	if jid, err := types.ParseJID(requester); err == nil {
+		return b.displayName(jid, "")
+	}
+	return requester
+}
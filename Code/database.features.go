@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// IsFeatureDisabled reports whether the owner turned feature off.
+func (a *AppDB) IsFeatureDisabled(ctx context.Context, feature string) (bool, error) {
+	if a == nil || a.db == nil {
+		return false, errors.New("db is nil")
+	}
+	var one int
+	err := a.db.QueryRowContext(ctx, `
+		SELECT 1 FROM app_disabled_features WHERE account = ? AND feature = ?
+		`, a.Account(), feature).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetFeatureDisabled turns feature off, or back on when disabled is false.
+func (a *AppDB) SetFeatureDisabled(ctx context.Context, feature string, disabled bool) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	query := `
+		DELETE FROM app_disabled_features WHERE account = ? AND feature = ?
+		`
+	if disabled {
+		query = `
+		INSERT INTO app_disabled_features (account, feature)
+		VALUES (?, ?)
+		ON CONFLICT(account, feature) DO NOTHING
+		`
+	}
+	_, err := a.db.ExecContext(ctx, query, a.Account(), feature)
+	return err
+}
@@ -5,19 +5,25 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// AppDB is the app store. Aliases and whitelists are namespaced by account
+// (the bot's own phone number), see ForAccount.
 type AppDB struct {
 	db *sql.DB
+
+	mu      sync.RWMutex
+	account string
 }
 
-// If dsn is empty, it defaults to using the existing file.
+// If dsn is empty, it defaults to app.db next to the binary.
 func OpenAppDB(ctx context.Context, dsn string) (*AppDB, error) {
 	if strings.TrimSpace(dsn) == "" {
-		dsn = "file:V5.db?_foreign_keys=on&busy_timeout=5000"
+		dsn = defaultAppDSN
 	}
 
 	db, err := sql.Open("sqlite3", dsn)
@@ -43,6 +49,32 @@ func OpenAppDB(ctx context.Context, dsn string) (*AppDB, error) {
 	return appDB, nil
 }
 
+// ForAccount returns a view of the same database whose namespaced queries only see account's rows.
+func (a *AppDB) ForAccount(account string) *AppDB {
+	if a == nil {
+		return nil
+	}
+	return &AppDB{db: a.db, account: account}
+}
+
+func (a *AppDB) Account() string {
+	if a == nil {
+		return ""
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.account
+}
+
+// SetAccount points this view at another account. Handlers may be using it
+// at the same time, which is why the bot keeps one view and changes its account
+// instead of swapping the view.
+func (a *AppDB) SetAccount(account string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.account = account
+}
+
 func (a *AppDB) Close() error {
 	if a == nil || a.db == nil {
 		return nil
@@ -54,14 +86,19 @@ func (a *AppDB) EnsureSchema(ctx context.Context) error {
 	if a == nil || a.db == nil {
 		return errors.New("db is nil")
 	}
+	if _, err := a.renameLegacyTables(ctx); err != nil {
+		return err
+	}
+
 	const schema = `
 		-- Table for app_aliases (already existing) --
 		CREATE TABLE IF NOT EXISTS app_aliases (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account TEXT NOT NULL DEFAULT '',
 			chat_jid TEXT NOT NULL,
 			sender_jid TEXT NOT NULL,
 			alias TEXT NOT NULL,
-			UNIQUE(chat_jid, sender_jid)
+			UNIQUE(account, chat_jid, sender_jid)
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_app_aliases_chat_jid
@@ -73,7 +110,9 @@ func (a *AppDB) EnsureSchema(ctx context.Context) error {
 		-- Table for group whitelist
 		CREATE TABLE IF NOT EXISTS app_group_whitelist (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			chat_jid TEXT NOT NULL UNIQUE
+			account TEXT NOT NULL DEFAULT '',
+			chat_jid TEXT NOT NULL,
+			UNIQUE(account, chat_jid)
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_app_group_whitelist_chat_jid
@@ -82,7 +121,9 @@ func (a *AppDB) EnsureSchema(ctx context.Context) error {
 		-- Table for user whitelist
 		CREATE TABLE IF NOT EXISTS app_user_whitelist (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			sender_jid TEXT NOT NULL UNIQUE
+			account TEXT NOT NULL DEFAULT '',
+			sender_jid TEXT NOT NULL,
+			UNIQUE(account, sender_jid)
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_app_user_whitelist_sender_jid
@@ -100,19 +141,133 @@ func (a *AppDB) EnsureSchema(ctx context.Context) error {
 
 		-- Table for MessageContext --
 		CREATE TABLE IF NOT EXISTS app_message_context (
-			message_id TEXT PRIMARY KEY NOT NULL,
+			account TEXT NOT NULL DEFAULT '',
+			message_id TEXT NOT NULL,
 			sender_name TEXT NOT NULL,
+			sender_jid TEXT NOT NULL DEFAULT '',
 			chat_id TEXT NOT NULL,
 			text TEXT,
 			media_description Text,
-			timestamp DATETIME NOT NULL
+			timestamp DATETIME NOT NULL,
+			PRIMARY KEY(account, message_id)
 			);
 
 		CREATE INDEX IF NOT EXISTS idx_app_message_context_chat_id
-			ON app_message_context(chat_id);
+			ON app_message_context(account, chat_id);
+
+		-- Token buckets and daily spend for LLM commands --
+		CREATE TABLE IF NOT EXISTS app_quota_buckets (
+			account TEXT NOT NULL DEFAULT '',
+			bucket_key TEXT NOT NULL,
+			tokens REAL NOT NULL,
+			updated_unix REAL NOT NULL,
+			PRIMARY KEY(account, bucket_key)
+		);
+
+		CREATE TABLE IF NOT EXISTS app_quota_daily (
+			account TEXT NOT NULL DEFAULT '',
+			quota_key TEXT NOT NULL,
+			day TEXT NOT NULL,
+			tokens INTEGER NOT NULL DEFAULT 0,
+			cost REAL NOT NULL DEFAULT 0,
+			PRIMARY KEY(account, quota_key, day)
+		);
+
+		-- One row per LLM call, for --usage --
+		CREATE TABLE IF NOT EXISTS app_llm_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account TEXT NOT NULL DEFAULT '',
+			chat_jid TEXT NOT NULL,
+			requester_jid TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			cost REAL NOT NULL DEFAULT 0,
+			success INTEGER NOT NULL,
+			error TEXT,
+			created_unix INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_app_llm_usage_created
+			ON app_llm_usage(account, created_unix);
+
+		-- Media the bot uploaded, so stickers and audio aren't uploaded every time --
+		CREATE TABLE IF NOT EXISTS app_media_uploads (
+			account TEXT NOT NULL DEFAULT '',
+			file_sha256 TEXT NOT NULL,
+			media_type TEXT NOT NULL,
+			url TEXT NOT NULL,
+			direct_path TEXT NOT NULL,
+			media_key BLOB NOT NULL,
+			file_enc_sha256 BLOB NOT NULL,
+			file_length INTEGER NOT NULL,
+			uploaded_unix INTEGER NOT NULL,
+			PRIMARY KEY(account, file_sha256, media_type)
+		);
+
+		-- Group names and members, from GetGroupInfo and group events --
+		CREATE TABLE IF NOT EXISTS app_groups (
+			account TEXT NOT NULL DEFAULT '',
+			chat_jid TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			topic TEXT NOT NULL DEFAULT '',
+			updated_unix INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(account, chat_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS app_group_participants (
+			account TEXT NOT NULL DEFAULT '',
+			chat_jid TEXT NOT NULL,
+			participant_jid TEXT NOT NULL,
+			is_admin INTEGER NOT NULL DEFAULT 0,
+			is_super_admin INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(account, chat_jid, participant_jid)
+		);
+
+		-- Groups the bot was added to that the owner hasn't approved yet --
+		CREATE TABLE IF NOT EXISTS app_pending_groups (
+			account TEXT NOT NULL DEFAULT '',
+			chat_jid TEXT NOT NULL,
+			deadline_unix INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(account, chat_jid)
+		);
+
+		-- One-off data migrations that already ran --
+		CREATE TABLE IF NOT EXISTS app_migrations (
+			name TEXT PRIMARY KEY,
+			applied_unix INTEGER NOT NULL
+		);
+
+		-- Features the owner turned off from the admin console --
+		CREATE TABLE IF NOT EXISTS app_disabled_features (
+			account TEXT NOT NULL DEFAULT '',
+			feature TEXT NOT NULL,
+			PRIMARY KEY(account, feature)
+		);
 	`
-	_, err := a.db.ExecContext(ctx, schema)
-	return err
+	if _, err := a.db.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+	if err := a.addMissingColumns(ctx); err != nil {
+		return err
+	}
+
+	// Needs sender_jid, which older databases only get from addMissingColumns.
+	const indexes = `
+		CREATE INDEX IF NOT EXISTS idx_app_message_context_sender_jid
+			ON app_message_context(account, chat_id, sender_jid);
+	`
+	if _, err := a.db.ExecContext(ctx, indexes); err != nil {
+		return err
+	}
+
+	// Also picks up tables a failed copy left behind on an earlier start.
+	legacy, err := a.legacyTables(ctx)
+	if err != nil {
+		return err
+	}
+	return a.copyLegacyTables(ctx, legacy)
 }
 
 // --- Alias methods ---
@@ -132,15 +287,16 @@ func (a *AppDB) SetAlias(ctx context.Context, chatJID string, senderJID string,
 
 	query := `
 		INSERT INTO app_aliases (
+		account,
 		chat_jid,
 		sender_jid,
 		alias
 		)
-		VALUES (?, ?, ?)
-		ON CONFLICT(chat_jid, sender_jid) DO UPDATE SET
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(account, chat_jid, sender_jid) DO UPDATE SET
 		alias = excluded.alias
 		`
-	_, err := a.db.ExecContext(ctx, query, chatJID, senderJID, alias)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), chatJID, senderJID, alias)
 
 	return err
 }
@@ -161,9 +317,9 @@ func (a *AppDB) GetAlias(ctx context.Context, chatJID string, senderJID string)
 	query := `
 		SELECT alias
 		FROM app_aliases
-		WHERE chat_jid = ? AND sender_jid = ?
+		WHERE account = ? AND chat_jid = ? AND sender_jid = ?
 		`
-	err := a.db.QueryRowContext(ctx, query, chatJID, senderJID).Scan(&alias)
+	err := a.db.QueryRowContext(ctx, query, a.Account(), chatJID, senderJID).Scan(&alias)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", false, nil
@@ -175,6 +331,51 @@ func (a *AppDB) GetAlias(ctx context.Context, chatJID string, senderJID string)
 	return alias, true, nil
 }
 
+// DeleteAlias removes the alias of senderJID in chatJID and reports whether there was one.
+func (a *AppDB) DeleteAlias(ctx context.Context, chatJID string, senderJID string) (bool, error) {
+	if a == nil || a.db == nil {
+		return false, errors.New("db is nil")
+	}
+
+	res, err := a.db.ExecContext(ctx, `
+		DELETE FROM app_aliases
+		WHERE account = ? AND chat_jid = ? AND sender_jid = ?
+		`, a.Account(), strings.TrimSpace(chatJID), strings.TrimSpace(senderJID))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListAliases returns every alias set in chatJID, sorted by alias.
+func (a *AppDB) ListAliases(ctx context.Context, chatJID string) ([]AliasEntry, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT sender_jid, alias
+		FROM app_aliases
+		WHERE account = ? AND chat_jid = ?
+		ORDER BY alias COLLATE NOCASE
+		`, a.Account(), strings.TrimSpace(chatJID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AliasEntry
+	for rows.Next() {
+		var entry AliasEntry
+		if err := rows.Scan(&entry.SenderJID, &entry.Alias); err != nil {
+			return nil, err
+		}
+		out = append(out, entry)
+	}
+	return out, rows.Err()
+}
+
 // --- Group Whitelist methods ---
 
 func (a *AppDB) AddGroupToWhitelist(ctx context.Context, chatJID string) error {
@@ -186,11 +387,11 @@ func (a *AppDB) AddGroupToWhitelist(ctx context.Context, chatJID string) error {
 		return errors.New("chatJID required")
 	}
 	query := `
-		INSERT INTO app_group_whitelist (chat_jid)
-		VALUES (?)
-		ON CONFLICT(chat_jid) DO NOTHING
+		INSERT INTO app_group_whitelist (account, chat_jid)
+		VALUES (?, ?)
+		ON CONFLICT(account, chat_jid) DO NOTHING
 		`
-	_, err := a.db.ExecContext(ctx, query, chatJID)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), chatJID)
 	return err
 }
 
@@ -205,9 +406,9 @@ func (a *AppDB) IsGroupWhitelisted(ctx context.Context, chatJID string) (bool, e
 
 	var id int64
 	query := `
-		SELECT id FROM app_group_whitelist WHERE chat_jid = ?
+		SELECT id FROM app_group_whitelist WHERE account = ? AND chat_jid = ?
 		`
-	err := a.db.QueryRowContext(ctx, query, chatJID).Scan(&id)
+	err := a.db.QueryRowContext(ctx, query, a.Account(), chatJID).Scan(&id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	}
@@ -226,12 +427,22 @@ func (a *AppDB) RemoveGroupFromWhitelist(ctx context.Context, chatJID string) er
 		return errors.New("chatJID required")
 	}
 	query := `
-		DELETE FROM app_group_whitelist WHERE chat_jid = ?
+		DELETE FROM app_group_whitelist WHERE account = ? AND chat_jid = ?
 		`
-	_, err := a.db.ExecContext(ctx, query, chatJID)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), chatJID)
 	return err
 }
 
+func (a *AppDB) ListWhitelistedGroups(ctx context.Context) ([]string, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	query := `
+		SELECT chat_jid FROM app_group_whitelist WHERE account = ? ORDER BY id
+		`
+	return a.queryStrings(ctx, query, a.Account())
+}
+
 // --- User Whitelist methods ---
 
 func (a *AppDB) AddUserToWhitelist(ctx context.Context, senderJID string) error {
@@ -243,11 +454,11 @@ func (a *AppDB) AddUserToWhitelist(ctx context.Context, senderJID string) error
 		return errors.New("senderJID required")
 	}
 	query := `
-		INSERT INTO app_user_whitelist (sender_jid)
-		VALUES (?)
-		ON CONFLICT(sender_jid) DO NOTHING
+		INSERT INTO app_user_whitelist (account, sender_jid)
+		VALUES (?, ?)
+		ON CONFLICT(account, sender_jid) DO NOTHING
 		`
-	_, err := a.db.ExecContext(ctx, query, senderJID)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), senderJID)
 	return err
 }
 
@@ -262,9 +473,9 @@ func (a *AppDB) IsUserWhitelisted(ctx context.Context, senderJID string) (bool,
 
 	var id int64
 	query := `
-		SELECT id FROM app_user_whitelist WHERE sender_jid = ?
+		SELECT id FROM app_user_whitelist WHERE account = ? AND sender_jid = ?
 		`
-	err := a.db.QueryRowContext(ctx, query, senderJID).Scan(&id)
+	err := a.db.QueryRowContext(ctx, query, a.Account(), senderJID).Scan(&id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	}
@@ -283,12 +494,41 @@ func (a *AppDB) RemoveUserFromWhitelist(ctx context.Context, senderJID string) e
 		return errors.New("senderJID required")
 	}
 	query := `
-		DELETE FROM app_user_whitelist WHERE sender_jid = ?
+		DELETE FROM app_user_whitelist WHERE account = ? AND sender_jid = ?
 		`
-	_, err := a.db.ExecContext(ctx, query, senderJID)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), senderJID)
 	return err
 }
 
+func (a *AppDB) ListWhitelistedUsers(ctx context.Context) ([]string, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	query := `
+		SELECT sender_jid FROM app_user_whitelist WHERE account = ? ORDER BY id
+		`
+	return a.queryStrings(ctx, query, a.Account())
+}
+
+// queryStrings runs a query that selects a single TEXT column and collects the rows.
+func (a *AppDB) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, rows.Err()
+}
+
 // --- Image Cache methods ---
 
 func (a *AppDB) AddImageDescription(ctx context.Context, id string, description string) error {
@@ -340,13 +580,8 @@ func (a *AppDB) InsertMessageContext(ctx context.Context, messageID string, chat
 	}
 	messageID = strings.TrimSpace(messageID)
 	chatID = strings.TrimSpace(chatID)
-
-	senderName, err := isAliasCached(GlobalAliasCache, chatID, senderID, GlobalAppDB)
-	isCacheMiss := err != nil || senderName == ""
-
-	if isCacheMiss {
-		setNewAliasCache(GlobalAliasCache, chatID, senderID, senderName, GlobalAppDB)
-	}
+	senderID = strings.TrimSpace(senderID)
+	senderName = strings.TrimSpace(senderName)
 
 	if messageID == "" || chatID == "" || senderName == "" {
 		return errors.New("messageID, chatID and senderName are required")
@@ -354,22 +589,25 @@ func (a *AppDB) InsertMessageContext(ctx context.Context, messageID string, chat
 
 	query := `
 		INSERT INTO app_message_context (
+			account,
 			message_id,
 			chat_id,
 			sender_name,
+			sender_jid,
 			media_description,
 			text,
 			timestamp
 		)
-		VALUES (?, ?, ?, ?, ?, ?)
-		ON CONFLICT(message_id) DO UPDATE SET
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account, message_id) DO UPDATE SET
 			chat_id = excluded.chat_id,
 			sender_name = excluded.sender_name,
+			sender_jid = excluded.sender_jid,
 			media_description = excluded.media_description,
 			text = excluded.text,
 			timestamp = excluded.timestamp
 	`
-	_, err = a.db.ExecContext(ctx, query, messageID, chatID, senderName, mediaDescription, text, timestamp)
+	_, err := a.db.ExecContext(ctx, query, a.Account(), messageID, chatID, senderName, senderID, mediaDescription, text, timestamp)
 	return err
 }
 
@@ -385,9 +623,9 @@ func (a *AppDB) UpdateMessageContextMediaDescription(ctx context.Context, messag
 	query := `
 		UPDATE app_message_context
 		SET media_description = ?
-		WHERE message_id = ?
+		WHERE account = ? AND message_id = ?
 	`
-	_, err := a.db.ExecContext(ctx, query, mediaDescription, messageID)
+	_, err := a.db.ExecContext(ctx, query, mediaDescription, a.Account(), messageID)
 	return err
 }
 
@@ -403,8 +641,91 @@ func (a *AppDB) UpdateMessageContextText(ctx context.Context, messageID string,
 	query := `
 		UPDATE app_message_context
 		SET text = ?
-		WHERE message_id = ?
+		WHERE account = ? AND message_id = ?
 	`
-	_, err := a.db.ExecContext(ctx, query, text, messageID)
+	_, err := a.db.ExecContext(ctx, query, text, a.Account(), messageID)
 	return err
 }
+
+// ListMessageContext returns stored messages ordered from oldest to newest.
+// An empty chatID lists every chat; a limit <= 0 returns all rows.
+func (a *AppDB) ListMessageContext(ctx context.Context, chatID string, limit int) ([]StoredMessage, error) {
+	return a.ListMessageContextSince(ctx, chatID, time.Time{}, limit)
+}
+
+// ListMessageContextSince is ListMessageContext restricted to messages sent at
+// or after since. A zero since means no lower bound.
+func (a *AppDB) ListMessageContextSince(ctx context.Context, chatID string, since time.Time, limit int) ([]StoredMessage, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	chatID = strings.TrimSpace(chatID)
+	if limit <= 0 {
+		limit = -1
+	}
+	var sinceUnix int64
+	if !since.IsZero() {
+		sinceUnix = since.Unix()
+	}
+
+	// Timestamps are stored with their own UTC offset, so compare them as unix time.
+	query := `
+		SELECT message_id, chat_id, sender_name, sender_jid, text, media_description, timestamp
+		FROM (
+			SELECT * FROM app_message_context
+			WHERE account = ?
+				AND (? = '' OR chat_id = ?)
+				AND (? = 0 OR unixepoch(timestamp) >= ?)
+			ORDER BY timestamp DESC
+			LIMIT ?
+		)
+		ORDER BY timestamp ASC
+	`
+	rows, err := a.db.QueryContext(ctx, query, a.Account(), chatID, chatID, sinceUnix, sinceUnix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StoredMessage
+	for rows.Next() {
+		var msg StoredMessage
+		var text, mediaDescription sql.NullString
+		if err := rows.Scan(&msg.MessageID, &msg.ChatID, &msg.SenderName, &msg.SenderJID, &text, &mediaDescription, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		msg.Text = text.String
+		msg.MediaDescription = mediaDescription.String
+		out = append(out, msg)
+	}
+	return out, rows.Err()
+}
+
+// LastMessageTime returns when senderJID last wrote in chatID before the given
+// time. The bool is false if they have no stored messages there.
+func (a *AppDB) LastMessageTime(ctx context.Context, chatID string, senderJID string, before time.Time) (time.Time, bool, error) {
+	if a == nil || a.db == nil {
+		return time.Time{}, false, errors.New("db is nil")
+	}
+	chatID = strings.TrimSpace(chatID)
+	senderJID = strings.TrimSpace(senderJID)
+	if chatID == "" || senderJID == "" {
+		return time.Time{}, false, errors.New("chatID and senderJID are required")
+	}
+
+	query := `
+		SELECT timestamp FROM app_message_context
+		WHERE account = ? AND chat_id = ? AND sender_jid = ? AND unixepoch(timestamp) < ?
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`
+	var last time.Time
+	err := a.db.QueryRowContext(ctx, query, a.Account(), chatID, senderJID, before.Unix()).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return last, true, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SaveGroup stores a full snapshot of a group, replacing its participant list.
+func (a *AppDB) SaveGroup(ctx context.Context, meta GroupMeta) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	if meta.ChatJID == "" {
+		return errors.New("chatJID is required")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO app_groups (account, chat_jid, name, topic, updated_unix)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account, chat_jid) DO UPDATE SET
+			name = excluded.name,
+			topic = excluded.topic,
+			updated_unix = excluded.updated_unix
+		`, a.Account(), meta.ChatJID, meta.Name, meta.Topic, meta.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM app_group_participants WHERE account = ? AND chat_jid = ?
+		`, a.Account(), meta.ChatJID); err != nil {
+		return err
+	}
+	for _, member := range meta.Participants {
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO app_group_participants (account, chat_jid, participant_jid, is_admin, is_super_admin)
+			VALUES (?, ?, ?, ?, ?)
+			`, a.Account(), meta.ChatJID, member.JID, member.IsAdmin, member.IsSuperAdmin)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteGroup forgets a group the bot is no longer in: its info, participants
+// and any pending approval. Its messages and whitelist entry are kept.
+func (a *AppDB) DeleteGroup(ctx context.Context, chatJID string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"app_groups", "app_group_participants", "app_pending_groups"} {
+		query := `DELETE FROM ` + table + ` WHERE account = ? AND chat_jid = ?`
+		if _, err := tx.ExecContext(ctx, query, a.Account(), chatJID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetGroup returns what is stored about a group. The bool is false if nothing is.
+func (a *AppDB) GetGroup(ctx context.Context, chatJID string) (*GroupMeta, bool, error) {
+	if a == nil || a.db == nil {
+		return nil, false, errors.New("db is nil")
+	}
+
+	meta := &GroupMeta{ChatJID: chatJID}
+	var updated int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT name, topic, updated_unix FROM app_groups
+		WHERE account = ? AND chat_jid = ?
+		`, a.Account(), chatJID).Scan(&meta.Name, &meta.Topic, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if updated > 0 {
+		meta.UpdatedAt = time.Unix(updated, 0)
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT participant_jid, is_admin, is_super_admin FROM app_group_participants
+		WHERE account = ? AND chat_jid = ?
+		ORDER BY participant_jid
+		`, a.Account(), chatJID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member GroupMember
+		if err := rows.Scan(&member.JID, &member.IsAdmin, &member.IsSuperAdmin); err != nil {
+			return nil, false, err
+		}
+		meta.Participants = append(meta.Participants, member)
+	}
+	return meta, true, rows.Err()
+}
+
+// UpdateGroupDetails changes a group's name and/or topic; nil leaves a field as it is.
+func (a *AppDB) UpdateGroupDetails(ctx context.Context, chatJID string, name, topic *string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO app_groups (account, chat_jid, name, topic)
+		VALUES (?, ?, COALESCE(?, ''), COALESCE(?, ''))
+		ON CONFLICT(account, chat_jid) DO UPDATE SET
+			name = COALESCE(?, name),
+			topic = COALESCE(?, topic)
+		`, a.Account(), chatJID, name, topic, name, topic)
+	return err
+}
+
+// AddGroupParticipants records members joining a group, as non-admins.
+func (a *AppDB) AddGroupParticipants(ctx context.Context, chatJID string, participantJIDs []string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, jid := range participantJIDs {
+		_, err := a.db.ExecContext(ctx, `
+			INSERT OR IGNORE INTO app_group_participants (account, chat_jid, participant_jid)
+			VALUES (?, ?, ?)
+			`, a.Account(), chatJID, jid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveGroupParticipants records members leaving a group.
+func (a *AppDB) RemoveGroupParticipants(ctx context.Context, chatJID string, participantJIDs []string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, jid := range participantJIDs {
+		_, err := a.db.ExecContext(ctx, `
+			DELETE FROM app_group_participants
+			WHERE account = ? AND chat_jid = ? AND participant_jid = ?
+			`, a.Account(), chatJID, jid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGroupAdmins promotes or demotes members of a group.
+func (a *AppDB) SetGroupAdmins(ctx context.Context, chatJID string, participantJIDs []string, admin bool) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, jid := range participantJIDs {
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO app_group_participants (account, chat_jid, participant_jid, is_admin)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(account, chat_jid, participant_jid) DO UPDATE SET
+				is_admin = excluded.is_admin,
+				is_super_admin = CASE WHEN excluded.is_admin THEN is_super_admin ELSE 0 END
+			`, a.Account(), chatJID, jid, admin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ? ----------------------------------------------Pending Groups----------------------------------------------
+
+// AddPendingGroup records a group waiting for the owner's approval. A zero
+// deadline means the bot never leaves it on its own.
+func (a *AppDB) AddPendingGroup(ctx context.Context, chatJID string, deadline time.Time) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	var deadlineUnix int64
+	if !deadline.IsZero() {
+		deadlineUnix = deadline.Unix()
+	}
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO app_pending_groups (account, chat_jid, deadline_unix)
+		VALUES (?, ?, ?)
+		ON CONFLICT(account, chat_jid) DO UPDATE SET deadline_unix = excluded.deadline_unix
+		`, a.Account(), chatJID, deadlineUnix)
+	return err
+}
+
+// RemovePendingGroup clears a group's pending state and reports whether it was pending.
+func (a *AppDB) RemovePendingGroup(ctx context.Context, chatJID string) (bool, error) {
+	if a == nil || a.db == nil {
+		return false, errors.New("db is nil")
+	}
+	res, err := a.db.ExecContext(ctx, `
+		DELETE FROM app_pending_groups WHERE account = ? AND chat_jid = ?
+		`, a.Account(), chatJID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListPendingGroups returns the groups waiting for approval, oldest deadline first.
+func (a *AppDB) ListPendingGroups(ctx context.Context) ([]PendingGroup, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT chat_jid, deadline_unix FROM app_pending_groups
+		WHERE account = ?
+		ORDER BY deadline_unix
+		`, a.Account())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PendingGroup
+	for rows.Next() {
+		var pending PendingGroup
+		var deadline int64
+		if err := rows.Scan(&pending.ChatJID, &deadline); err != nil {
+			return nil, err
+		}
+		if deadline > 0 {
+			pending.Deadline = time.Unix(deadline, 0)
+		}
+		out = append(out, pending)
+	}
+	return out, rows.Err()
+}
+
+// ListGroups returns every group the bot has stored, by name, without participants.
+func (a *AppDB) ListGroups(ctx context.Context) ([]GroupMeta, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT chat_jid, name, topic, updated_unix FROM app_groups
+		WHERE account = ?
+		ORDER BY name COLLATE NOCASE, chat_jid
+		`, a.Account())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []GroupMeta
+	for rows.Next() {
+		var meta GroupMeta
+		var updated int64
+		if err := rows.Scan(&meta.ChatJID, &meta.Name, &meta.Topic, &updated); err != nil {
+			return nil, err
+		}
+		if updated > 0 {
+			meta.UpdatedAt = time.Unix(updated, 0)
+		}
+		out = append(out, meta)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// GetMediaUpload returns a cached upload of the file with the given SHA-256 and
+// when it was made. The bool is false if the file was never uploaded.
+func (a *AppDB) GetMediaUpload(ctx context.Context, fileSHA256 []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, time.Time, bool, error) {
+	if a == nil || a.db == nil {
+		return nil, time.Time{}, false, errors.New("db is nil")
+	}
+
+	upload := &whatsmeow.UploadResponse{FileSHA256: fileSHA256}
+	var uploaded int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT url, direct_path, media_key, file_enc_sha256, file_length, uploaded_unix
+		FROM app_media_uploads
+		WHERE account = ? AND file_sha256 = ? AND media_type = ?
+		`, a.Account(), hex.EncodeToString(fileSHA256), string(mediaType)).Scan(
+		&upload.URL, &upload.DirectPath, &upload.MediaKey, &upload.FileEncSHA256, &upload.FileLength, &uploaded,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return upload, time.Unix(uploaded, 0), true, nil
+}
+
+// SaveMediaUpload caches an upload, replacing any older one of the same file.
+func (a *AppDB) SaveMediaUpload(ctx context.Context, upload *whatsmeow.UploadResponse, mediaType whatsmeow.MediaType, uploadedAt time.Time) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	if upload == nil || len(upload.FileSHA256) == 0 {
+		return errors.New("upload with a file hash is required")
+	}
+
+	query := `
+		INSERT INTO app_media_uploads (
+			account,
+			file_sha256,
+			media_type,
+			url,
+			direct_path,
+			media_key,
+			file_enc_sha256,
+			file_length,
+			uploaded_unix
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account, file_sha256, media_type) DO UPDATE SET
+			url = excluded.url,
+			direct_path = excluded.direct_path,
+			media_key = excluded.media_key,
+			file_enc_sha256 = excluded.file_enc_sha256,
+			file_length = excluded.file_length,
+			uploaded_unix = excluded.uploaded_unix
+	`
+	_, err := a.db.ExecContext(ctx, query,
+		a.Account(),
+		hex.EncodeToString(upload.FileSHA256),
+		string(mediaType),
+		upload.URL,
+		upload.DirectPath,
+		upload.MediaKey,
+		upload.FileEncSHA256,
+		upload.FileLength,
+		uploadedAt.Unix(),
+	)
+	return err
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// accountScopedTables lists the tables that gained an account column for
+// multi-account support, with the columns carried over from the old layout.
+var accountScopedTables = map[string][]string{
+	"app_aliases":         {"chat_jid", "sender_jid", "alias"},
+	"app_group_whitelist": {"chat_jid"},
+	"app_user_whitelist":  {"sender_jid"},
+	"app_message_context": {"message_id", "chat_id", "sender_name", "sender_jid", "text", "media_description", "timestamp"},
+}
+
+// addedColumns lists columns added to existing tables after their first release.
+var addedColumns = map[string][]string{
+	"app_message_context": {"sender_jid TEXT NOT NULL DEFAULT ''"},
+}
+
+// addMissingColumns adds any column from addedColumns that an older database lacks,
+// including a renamed _legacy copy so copyLegacyTables can read it. Rows written
+// before the column existed keep its default.
+func (a *AppDB) addMissingColumns(ctx context.Context) error {
+	for name, definitions := range addedColumns {
+		for _, table := range []string{name, name + "_legacy"} {
+			if err := a.addColumns(ctx, table, definitions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addColumns adds the columns in definitions that table lacks. A table that
+// doesn't exist is left alone.
+func (a *AppDB) addColumns(ctx context.Context, table string, definitions []string) error {
+	columns, err := a.columnNames(ctx, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	for _, definition := range definitions {
+		name := strings.Fields(definition)[0]
+		if slices.Contains(columns, name) {
+			continue
+		}
+		query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, definition)
+		if _, err := a.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", table, name, err)
+		}
+	}
+	return nil
+}
+
+// columnNames returns the column names of table, or nil if it does not exist.
+func (a *AppDB) columnNames(ctx context.Context, table string) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// renameLegacyTables moves pre-multi-account tables out of the way so EnsureSchema
+// can create the new layout. It returns the tables that were renamed.
+func (a *AppDB) renameLegacyTables(ctx context.Context) ([]string, error) {
+	var renamed []string
+	for table := range accountScopedTables {
+		columns, err := a.columnNames(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 || slices.Contains(columns, "account") {
+			continue
+		}
+
+		// Indexes follow a renamed table, so drop them or the new ones would be skipped.
+		indexes, err := a.queryStrings(ctx, `
+			SELECT name FROM sqlite_master
+			WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL
+			`, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if _, err := a.db.ExecContext(ctx, `DROP INDEX "`+index+`"`); err != nil {
+				return nil, err
+			}
+		}
+
+		query := fmt.Sprintf(`ALTER TABLE %s RENAME TO %s_legacy`, table, table)
+		if _, err := a.db.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+		renamed = append(renamed, table)
+	}
+	return renamed, nil
+}
+
+// copyLegacyTables moves rows from the renamed tables into the new ones with an
+// empty account, to be adopted later by ClaimUnownedRows. It runs in one
+// transaction, so a failure leaves the _legacy tables to retry from on the next start.
+func (a *AppDB) copyLegacyTables(ctx context.Context, tables []string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		columns := strings.Join(accountScopedTables[table], ", ")
+		query := fmt.Sprintf(`INSERT INTO %s (account, %s) SELECT '', %s FROM %s_legacy`, table, columns, columns, table)
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", table, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s_legacy`, table)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacyTables returns the _legacy tables a failed copyLegacyTables left behind.
+func (a *AppDB) legacyTables(ctx context.Context) ([]string, error) {
+	var tables []string
+	for table := range accountScopedTables {
+		columns, err := a.columnNames(ctx, table+"_legacy")
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// claimMigrations lists the app_migrations entries ClaimUnownedRows records, each
+// with the tables it adopts. Message context became account-scoped later, so it
+// has its own entry for databases that already ran the first one.
+var claimMigrations = []struct {
+	name   string
+	tables []string
+}{
+	{"claim-unowned-rows", []string{"app_aliases", "app_group_whitelist", "app_user_whitelist"}},
+	{"claim-unowned-messages", []string{"app_message_context"}},
+}
+
+// ClaimUnownedRows assigns rows migrated from the single-account layout to this
+// AppDB's account. Each claim only ever runs once per database, so rows of other
+// accounts can't be picked up by whichever account starts first later on.
+func (a *AppDB) ClaimUnownedRows(ctx context.Context) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	account := a.Account()
+	if account == "" {
+		return nil
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, claim := range claimMigrations {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO app_migrations (name, applied_unix) VALUES (?, ?)
+			ON CONFLICT(name) DO NOTHING
+			`, claim.name, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+
+		for _, table := range claim.tables {
+			query := fmt.Sprintf(`UPDATE OR IGNORE %s SET account = ? WHERE account = ''`, table)
+			if _, err := tx.ExecContext(ctx, query, account); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// MigrationApplied reports whether the app_migrations entry name was recorded.
+func (a *AppDB) MigrationApplied(ctx context.Context, name string) (bool, error) {
+	if a == nil || a.db == nil {
+		return false, errors.New("db is nil")
+	}
+	done, err := a.queryStrings(ctx, `SELECT name FROM app_migrations WHERE name = ?`, name)
+	return len(done) > 0, err
+}
+
+// RecordMigration records the app_migrations entry name. It returns false if
+// it was already there, so of two callers racing only one goes ahead.
+func (a *AppDB) RecordMigration(ctx context.Context, name string) (bool, error) {
+	if a == nil || a.db == nil {
+		return false, errors.New("db is nil")
+	}
+	res, err := a.db.ExecContext(ctx, `
+		INSERT INTO app_migrations (name, applied_unix) VALUES (?, ?)
+		ON CONFLICT(name) DO NOTHING
+		`, name, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// canonicalJIDsMigration is the app_migrations entry recorded once every
+// phone-number JID stored before canonicalJID existed was rewritten to its LID.
+const canonicalJIDsMigration = "canonical-jids"
+
+// personColumns lists the columns that store someone's JID, as [table, column].
+var personColumns = [][2]string{
+	{"app_aliases", "sender_jid"},
+	{"app_user_whitelist", "sender_jid"},
+	{"app_message_context", "sender_jid"},
+	{"app_llm_usage", "requester_jid"},
+}
+
+// PhoneNumberJIDs returns the phone-number JIDs stored in personColumns and in
+// per-user quota keys. It returns nil once RewritePhoneNumberJIDs has moved all of them.
+func (a *AppDB) PhoneNumberJIDs(ctx context.Context) ([]string, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	done, err := a.queryStrings(ctx, `SELECT name FROM app_migrations WHERE name = ?`, canonicalJIDsMigration)
+	if err != nil || len(done) > 0 {
+		return nil, err
+	}
+	return a.queryStrings(ctx, phoneNumberJIDsQuery())
+}
+
+func phoneNumberJIDsQuery() string {
+	var selects []string
+	for _, column := range personColumns {
+		selects = append(selects, fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIKE '%%@s.whatsapp.net'`, column[1], column[0], column[1]))
+	}
+	selects = append(selects,
+		`SELECT substr(quota_key, 6) FROM app_quota_daily WHERE quota_key LIKE 'user:%@s.whatsapp.net'`,
+		`SELECT substr(bucket_key, instr(bucket_key, 'user:') + 5) FROM app_quota_buckets WHERE bucket_key LIKE '%user:%@s.whatsapp.net'`,
+	)
+	return strings.Join(selects, " UNION ")
+}
+
+// RewritePhoneNumberJIDs replaces each phone-number JID in lids with its LID
+// wherever PhoneNumberJIDs found it. Phone numbers whose LID isn't known yet
+// stay, to be moved on a later run; once none are left this is recorded and
+// never runs again. Where a row already exists under the LID, that row wins;
+// daily quota usage is added to it instead.
+func (a *AppDB) RewritePhoneNumberJIDs(ctx context.Context, lids map[string]string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for pn, lid := range lids {
+		for _, column := range personColumns {
+			table, name := column[0], column[1]
+			query := fmt.Sprintf(`UPDATE OR IGNORE %s SET %s = ? WHERE %s = ?`, table, name, name)
+			if _, err := tx.ExecContext(ctx, query, lid, pn); err != nil {
+				return fmt.Errorf("failed to rewrite %s.%s: %w", table, name, err)
+			}
+			// Left behind only where the LID already had a row of its own.
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, table, name), pn); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE OR IGNORE app_quota_buckets SET bucket_key = replace(bucket_key, ?, ?)
+			WHERE bucket_key = ? OR bucket_key LIKE '%:' || ?
+			`, "user:"+pn, "user:"+lid, "user:"+pn, "user:"+pn); err != nil {
+			return fmt.Errorf("failed to rewrite quota buckets: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM app_quota_buckets WHERE bucket_key = ? OR bucket_key LIKE '%:' || ?
+			`, "user:"+pn, "user:"+pn); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_quota_daily (account, quota_key, day, tokens, cost)
+			SELECT account, ?, day, tokens, cost FROM app_quota_daily WHERE quota_key = ?
+			ON CONFLICT(account, quota_key, day) DO UPDATE SET
+				tokens = tokens + excluded.tokens,
+				cost = cost + excluded.cost
+			`, "user:"+lid, "user:"+pn); err != nil {
+			return fmt.Errorf("failed to rewrite daily quotas: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM app_quota_daily WHERE quota_key = ?`, "user:"+pn); err != nil {
+			return err
+		}
+	}
+
+	var left int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM (`+phoneNumberJIDsQuery()+`)`).Scan(&left); err != nil {
+		return err
+	}
+	if left == 0 {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_migrations (name, applied_unix) VALUES (?, ?)
+			ON CONFLICT(name) DO NOTHING
+			`, canonicalJIDsMigration, time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeLegacyDB creates a database in the layout from before multi-account
+// support, with message context from before sender_jid was added.
+func writeLegacyDB(t *testing.T, path string, extra ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE app_aliases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_jid TEXT NOT NULL,
+			sender_jid TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			UNIQUE(chat_jid, sender_jid)
+		);
+		CREATE INDEX idx_app_aliases_chat_jid ON app_aliases(chat_jid);
+		CREATE TABLE app_group_whitelist (id INTEGER PRIMARY KEY AUTOINCREMENT, chat_jid TEXT NOT NULL UNIQUE);
+		CREATE TABLE app_user_whitelist (id INTEGER PRIMARY KEY AUTOINCREMENT, sender_jid TEXT NOT NULL UNIQUE);
+		CREATE TABLE app_message_context (
+			message_id TEXT PRIMARY KEY NOT NULL,
+			sender_name TEXT NOT NULL,
+			chat_id TEXT NOT NULL,
+			text TEXT,
+			media_description Text,
+			timestamp DATETIME NOT NULL
+		);
+		CREATE INDEX idx_app_message_context_chat_id ON app_message_context(chat_id);
+
+		INSERT INTO app_aliases (chat_jid, sender_jid, alias) VALUES ('g@g.us', 'u@lid', 'Ana');
+		INSERT INTO app_group_whitelist (chat_jid) VALUES ('g@g.us');
+		INSERT INTO app_user_whitelist (sender_jid) VALUES ('u@lid');
+		INSERT INTO app_message_context (message_id, sender_name, chat_id, text, timestamp)
+			VALUES ('M1', 'Ana', 'u@lid', 'hi', '2026-10-17 20:00:00+00:00');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range extra {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLegacyTablesMigrateAndAreClaimedOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	writeLegacyDB(t, path)
+
+	appDB, err := OpenAppDB(ctx, "file:"+path)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	defer appDB.Close()
+
+	if left, err := appDB.legacyTables(ctx); err != nil || len(left) != 0 {
+		t.Fatalf("legacy tables left behind: %v (%v)", left, err)
+	}
+	columns, _ := appDB.columnNames(ctx, "app_aliases")
+	if !slices.Contains(columns, "account") {
+		t.Fatalf("app_aliases wasn't rebuilt: %v", columns)
+	}
+	indexes, _ := appDB.queryStrings(ctx, `SELECT name FROM sqlite_master WHERE type = 'index' AND name = 'idx_app_aliases_chat_jid'`)
+	if len(indexes) != 1 {
+		t.Errorf("index wasn't recreated on the new table")
+	}
+
+	// Migrated rows belong to nobody until the first account claims them.
+	first, second := appDB.ForAccount("111"), appDB.ForAccount("222")
+	if ok, _ := first.IsGroupWhitelisted(ctx, "g@g.us"); ok {
+		t.Fatalf("unclaimed row visible to an account")
+	}
+	if err := first.ClaimUnownedRows(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if alias, found, _ := first.GetAlias(ctx, "g@g.us", "u@lid"); !found || alias != "Ana" {
+		t.Errorf("alias not claimed: %q %v", alias, found)
+	}
+	if ok, _ := first.IsUserWhitelisted(ctx, "u@lid"); !ok {
+		t.Errorf("user whitelist not claimed")
+	}
+	if messages, _ := first.ListMessageContext(ctx, "", 0); len(messages) != 1 || messages[0].Text != "hi" {
+		t.Errorf("message context not claimed: %+v", messages)
+	}
+	if messages, _ := second.ListMessageContext(ctx, "", 0); len(messages) != 0 {
+		t.Errorf("another account sees the claimed messages: %+v", messages)
+	}
+
+	// Rows stored without an account later on stay put: the claim already ran.
+	if err := appDB.AddGroupToWhitelist(ctx, "other@g.us"); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*AppDB{second, first} {
+		if err := db.ClaimUnownedRows(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := db.IsGroupWhitelisted(ctx, "other@g.us"); ok {
+			t.Errorf("account %s claimed rows after the migration ran", db.Account())
+		}
+	}
+}
+
+func TestRenameLegacyTablesOnlyTouchesOldLayout(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	writeLegacyDB(t, path)
+
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appDB := &AppDB{db: db}
+	defer appDB.Close()
+
+	renamed, err := appDB.renameLegacyTables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(renamed)
+	if !slices.Equal(renamed, []string{"app_aliases", "app_group_whitelist", "app_message_context", "app_user_whitelist"}) {
+		t.Fatalf("renamed %v", renamed)
+	}
+
+	// A copy that never happened is picked up on the next open.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenAppDB(ctx, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if left, _ := reopened.legacyTables(ctx); len(left) != 0 {
+		t.Fatalf("legacy tables left behind: %v", left)
+	}
+	var n int
+	if err := reopened.db.QueryRow(`SELECT count(*) FROM app_aliases WHERE account = ''`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("expected the alias to be copied, got %d (%v)", n, err)
+	}
+
+	// Nothing to rename in the new layout.
+	if renamed, err := reopened.renameLegacyTables(ctx); err != nil || len(renamed) != 0 {
+		t.Errorf("renamed new tables: %v (%v)", renamed, err)
+	}
+}
+
+func TestMessagesAreClaimedAfterAnEarlierClaim(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	// The aliases and whitelists were claimed before message context had an account.
+	writeLegacyDB(t, path,
+		`CREATE TABLE app_migrations (name TEXT PRIMARY KEY, applied_unix INTEGER NOT NULL)`,
+		`INSERT INTO app_migrations (name, applied_unix) VALUES ('claim-unowned-rows', 0)`,
+	)
+
+	appDB, err := OpenAppDB(ctx, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer appDB.Close()
+
+	first := appDB.ForAccount("111")
+	if err := first.ClaimUnownedRows(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := first.ListMessageContext(ctx, "u@lid", 0); len(messages) != 1 {
+		t.Errorf("message context not claimed: %+v", messages)
+	}
+	if ok, _ := first.IsGroupWhitelisted(ctx, "g@g.us"); ok {
+		t.Errorf("the first claim ran again")
+	}
+
+	// Both accounts can store the same message ID, e.g. a group message both bots see.
+	for _, db := range []*AppDB{first, appDB.ForAccount("222")} {
+		text := "seen by " + db.Account()
+		if err := db.InsertMessageContext(ctx, "M2", "g@g.us", "Ana", "u@lid", nil, &text, &time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages, _ := first.ListMessageContext(ctx, "g@g.us", 0); len(messages) != 1 || messages[0].Text != "seen by 111" {
+		t.Errorf("accounts overwrote each other's messages: %+v", messages)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// QuotaBucket is a token bucket holding at most Burst tokens and refilling at
+// Rate tokens per second. Every request takes one token.
+type QuotaBucket struct {
+	Key   string
+	Burst float64
+	Rate  float64
+}
+
+// TakeQuotaBuckets takes one token from every bucket, or from none of them.
+// It returns -1 on success, otherwise the index of the first empty bucket and
+// how long until it has a token again.
+func (a *AppDB) TakeQuotaBuckets(ctx context.Context, buckets []QuotaBucket, now time.Time) (int, time.Duration, error) {
+	if a == nil || a.db == nil {
+		return 0, 0, errors.New("db is nil")
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	nowUnix := float64(now.UnixNano()) / 1e9
+	levels := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		var tokens, updated float64
+		err := tx.QueryRowContext(ctx, `
+			SELECT tokens, updated_unix FROM app_quota_buckets
+			WHERE account = ? AND bucket_key = ?
+			`, a.Account(), bucket.Key).Scan(&tokens, &updated)
+		if errors.Is(err, sql.ErrNoRows) {
+			tokens, updated = bucket.Burst, nowUnix
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		tokens = math.Min(bucket.Burst, tokens+math.Max(0, nowUnix-updated)*bucket.Rate)
+		if tokens < 1 {
+			wait := time.Duration((1 - tokens) / bucket.Rate * float64(time.Second))
+			return i, wait, nil
+		}
+		levels[i] = tokens - 1
+	}
+
+	for i, bucket := range buckets {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO app_quota_buckets (account, bucket_key, tokens, updated_unix)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(account, bucket_key) DO UPDATE SET
+				tokens = excluded.tokens,
+				updated_unix = excluded.updated_unix
+			`, a.Account(), bucket.Key, levels[i], nowUnix)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return -1, 0, tx.Commit()
+}
+
+// RefundQuotaBuckets puts back the token TakeQuotaBuckets took from each bucket,
+// without going over its burst.
+func (a *AppDB) RefundQuotaBuckets(ctx context.Context, buckets []QuotaBucket) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, bucket := range buckets {
+		_, err := a.db.ExecContext(ctx, `
+			UPDATE app_quota_buckets SET tokens = MIN(?, tokens + 1)
+			WHERE account = ? AND bucket_key = ?
+			`, bucket.Burst, a.Account(), bucket.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DailyQuotaUsage returns the tokens and cost recorded for key on day ("2006-01-02").
+func (a *AppDB) DailyQuotaUsage(ctx context.Context, key string, day string) (int, float64, error) {
+	if a == nil || a.db == nil {
+		return 0, 0, errors.New("db is nil")
+	}
+
+	var tokens int
+	var cost float64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT tokens, cost FROM app_quota_daily
+		WHERE account = ? AND quota_key = ? AND day = ?
+		`, a.Account(), key, day).Scan(&tokens, &cost)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return tokens, cost, err
+}
+
+// AddDailyQuotaUsage adds tokens and cost to each key's total for day.
+func (a *AppDB) AddDailyQuotaUsage(ctx context.Context, day string, keys []string, tokens int, cost float64) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, key := range keys {
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO app_quota_daily (account, quota_key, day, tokens, cost)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(account, quota_key, day) DO UPDATE SET
+				tokens = tokens + excluded.tokens,
+				cost = cost + excluded.cost
+			`, a.Account(), key, day, tokens, cost)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetQuotas refills the buckets and clears the daily totals of the given keys.
+// A key also covers buckets that extend it, so "user:x" resets "reason:user:x".
+func (a *AppDB) ResetQuotas(ctx context.Context, keys []string) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+	for _, key := range keys {
+		if _, err := a.db.ExecContext(ctx, `
+			DELETE FROM app_quota_buckets WHERE account = ? AND (bucket_key = ? OR bucket_key LIKE '%:' || ?)
+			`, a.Account(), key, key); err != nil {
+			return err
+		}
+		if _, err := a.db.ExecContext(ctx, `
+			DELETE FROM app_quota_daily WHERE account = ? AND quota_key = ?
+			`, a.Account(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
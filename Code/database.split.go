@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SplitCombinedDB splits a database that holds both the whatsmeow session
+// tables and the app_* tables (the old V5.db layout) into two new files.
+// The source file is only read; both targets must not exist yet.
+func SplitCombinedDB(ctx context.Context, srcPath, sessionPath, appPath string) error {
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+	if sessionPath == appPath {
+		return errors.New("session and app targets must be different files")
+	}
+	for _, target := range []string{sessionPath, appPath} {
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("%s already exists", target)
+		}
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+srcPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// VACUUM INTO takes a consistent snapshot, even if the bot still has the file open.
+	for _, target := range []string{sessionPath, appPath} {
+		if _, err := src.ExecContext(ctx, "VACUUM INTO ?", target); err != nil {
+			return fmt.Errorf("failed to copy into %s: %w", target, err)
+		}
+	}
+
+	if err := dropTablesWithPrefix(ctx, sessionPath, "app_"); err != nil {
+		return fmt.Errorf("failed to clean %s: %w", sessionPath, err)
+	}
+	if err := dropTablesWithPrefix(ctx, appPath, "whatsmeow_"); err != nil {
+		return fmt.Errorf("failed to clean %s: %w", appPath, err)
+	}
+
+	return nil
+}
+
+// dropTablesWithPrefix drops every table in the SQLite file whose name starts with prefix.
+func dropTablesWithPrefix(ctx context.Context, path string, prefix string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=off")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	query := `
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND substr(name, 1, ?) = ?
+	`
+	rows, err := db.QueryContext(ctx, query, len(prefix), prefix)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := db.ExecContext(ctx, `DROP TABLE "`+table+`"`); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+// legacyCombinedDB is the file older versions kept both stores in.
+const legacyCombinedDB = "V5.db"
+
+// dsnPath returns the file a SQLite DSN points at, or "" for in-memory databases.
+func dsnPath(dsn string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	if path == "" || path == ":memory:" {
+		return ""
+	}
+	return path
+}
+
+// checkLegacyDB refuses to start on an install that was never split: with a
+// V5.db next to it but no session database, the bot would come up logged out
+// and with empty app data.
+func checkLegacyDB(sessionDSN string) error {
+	sessionPath := dsnPath(sessionDSN)
+	if sessionPath == "" {
+		return nil
+	}
+	if _, err := os.Stat(sessionPath); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(legacyCombinedDB); err != nil {
+		return nil
+	}
+	return fmt.Errorf("found %s from an older version but no %s. Run `bancho split-db` to move your login and data over, or pass -session-dsn and -app-dsn to keep using %s",
+		legacyCombinedDB, sessionPath, legacyCombinedDB)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tableNames lists the tables in the SQLite file at path.
+func tableNames(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestSplitCombinedDB(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "V5.db")
+	sessionPath := filepath.Join(dir, "session.db")
+	appPath := filepath.Join(dir, "app.db")
+
+	db, err := sql.Open("sqlite3", "file:"+src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE whatsmeow_device (jid TEXT PRIMARY KEY);
+		INSERT INTO whatsmeow_device VALUES ('5215500000000:1@s.whatsapp.net');
+		CREATE TABLE app_aliases (chat_jid TEXT, sender_jid TEXT, alias TEXT);
+		INSERT INTO app_aliases VALUES ('g@g.us', 'u@lid', 'Ana');
+	`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SplitCombinedDB(ctx, src, sessionPath, appPath); err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	if got := tableNames(t, sessionPath); !slices.Equal(got, []string{"whatsmeow_device"}) {
+		t.Errorf("session tables: %v", got)
+	}
+	if got := tableNames(t, appPath); !slices.Equal(got, []string{"app_aliases"}) {
+		t.Errorf("app tables: %v", got)
+	}
+	if got := tableNames(t, src); len(got) != 2 {
+		t.Errorf("source was modified: %v", got)
+	}
+
+	if err := SplitCombinedDB(ctx, src, sessionPath, filepath.Join(dir, "other.db")); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected existing targets to be refused, got %v", err)
+	}
+	if err := SplitCombinedDB(ctx, src, appPath+"2", appPath+"2"); err == nil {
+		t.Errorf("expected identical targets to be refused")
+	}
+}
+
+func TestCheckLegacyDB(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := checkLegacyDB(defaultSessionDSN); err != nil {
+		t.Fatalf("fresh install refused: %v", err)
+	}
+
+	if err := os.WriteFile(legacyCombinedDB, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLegacyDB(defaultSessionDSN); err == nil || !strings.Contains(err.Error(), "split-db") {
+		t.Fatalf("expected to be pointed at split-db, got %v", err)
+	}
+	if err := checkLegacyDB("file:" + legacyCombinedDB + "?_foreign_keys=on"); err != nil {
+		t.Errorf("explicitly using %s was refused: %v", legacyCombinedDB, err)
+	}
+	if err := checkLegacyDB("file::memory:"); err != nil {
+		t.Errorf("in-memory session refused: %v", err)
+	}
+
+	if err := os.WriteFile("session.db", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLegacyDB(defaultSessionDSN); err != nil {
+		t.Errorf("split install refused: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InsertLLMUsage records one model call.
+func (a *AppDB) InsertLLMUsage(ctx context.Context, usage LLMUsage) error {
+	if a == nil || a.db == nil {
+		return errors.New("db is nil")
+	}
+
+	var errText *string
+	if usage.Error != "" {
+		errText = &usage.Error
+	}
+	query := `
+		INSERT INTO app_llm_usage (
+			account,
+			chat_jid,
+			requester_jid,
+			model,
+			prompt_tokens,
+			completion_tokens,
+			latency_ms,
+			cost,
+			success,
+			error,
+			created_unix
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := a.db.ExecContext(ctx, query,
+		a.Account(),
+		strings.TrimSpace(usage.ChatJID),
+		strings.TrimSpace(usage.RequesterJID),
+		usage.Model,
+		usage.PromptTokens,
+		usage.CompletionTokens,
+		usage.Latency.Milliseconds(),
+		usage.Cost,
+		usage.Success,
+		errText,
+		usage.CreatedAt.Unix(),
+	)
+	return err
+}
+
+// UsageTotals sums calls made since the given time, grouped by groupBy ("chat_jid",
+// "requester_jid" or "" for a single overall row) and ordered by cost. An empty
+// chatJID covers every chat. A limit <= 0 returns all groups.
+func (a *AppDB) UsageTotals(ctx context.Context, groupBy string, chatJID string, since time.Time, limit int) ([]UsageTotal, error) {
+	if a == nil || a.db == nil {
+		return nil, errors.New("db is nil")
+	}
+	key := `''`
+	switch groupBy {
+	case "":
+	case "chat_jid", "requester_jid":
+		key = groupBy
+	default:
+		return nil, fmt.Errorf("can't group usage by %q", groupBy)
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS usage_key,
+			COUNT(*),
+			COALESCE(SUM(prompt_tokens + completion_tokens), 0),
+			COALESCE(SUM(cost), 0)
+		FROM app_llm_usage
+		WHERE account = ? AND created_unix >= ? AND (? = '' OR chat_jid = ?)
+		GROUP BY usage_key
+		ORDER BY SUM(cost) DESC
+		LIMIT ?
+	`, key)
+	chatJID = strings.TrimSpace(chatJID)
+	rows, err := a.db.QueryContext(ctx, query, a.Account(), since.Unix(), chatJID, chatJID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []UsageTotal
+	for rows.Next() {
+		var total UsageTotal
+		if err := rows.Scan(&total.Key, &total.Calls, &total.Tokens, &total.Cost); err != nil {
+			return nil, err
+		}
+		out = append(out, total)
+	}
+	return out, rows.Err()
+}
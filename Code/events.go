@@ -10,16 +10,52 @@ import (
 	"go.mau.fi/whatsmeow/types/events"
 )
 
-func eventHandler(evt interface{}) {
+func (b *Bot) eventHandler(evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
+		if !b.Shutdown.Enter() {
+			return
+		}
+		defer b.Shutdown.Leave()
+
 		ctx, err := ParseMessageEvent(v)
 		if err != nil {
 			return
 		}
-		// TODO: implement whitelist check
-		splitMessages(ctx)
+		b.splitMessages(b.Shutdown.Context(), ctx)
 		ctx.Print()
+
+	case *events.GroupInfo:
+		if !b.Shutdown.Enter() {
+			return
+		}
+		defer b.Shutdown.Leave()
+		b.handleGroupInfo(b.Shutdown.Context(), v)
+
+	case *events.JoinedGroup:
+		if !b.Shutdown.Enter() {
+			return
+		}
+		defer b.Shutdown.Leave()
+		b.handleJoinedGroup(b.Shutdown.Context(), v)
+
+	case *events.PairSuccess:
+		b.syncAccount(b.Shutdown.Context())
+
+	case *events.Connected:
+		// Before the offline messages arrive, so they're stored under the right account.
+		b.syncAccount(b.Shutdown.Context())
+		if err := b.canonicalizeStoredJIDs(b.Shutdown.Context()); err != nil {
+			fmt.Printf("Failed to move stored phone numbers to LIDs: %v\n", err)
+		}
+		if b.Shutdown.Enter() {
+			b.Shutdown.Go(b.noticeUnlistedGroups)
+			b.Shutdown.Leave()
+		}
+		b.Supervisor.HandleEvent(v)
+
+	case *events.Disconnected, *events.ConnectFailure, *events.LoggedOut, *events.StreamReplaced:
+		b.Supervisor.HandleEvent(v)
 	}
 }
 
@@ -27,26 +63,38 @@ func eventHandler(evt interface{}) {
 // ? ----------------------------------------------Message Splitter------------------------------------------
 // ? --------------------------------------------------------------------------------------------------------
 
-func splitMessages(ctx *MessageContext) {
+func (b *Bot) splitMessages(rootCtx context.Context, ctx *MessageContext) {
 	switch ctx.MediaType {
 	case "image":
-		handleImageMessage(ctx)
+		b.handleImageMessage(rootCtx, ctx)
 		return
 	case "video":
-		handleVideoMessage(ctx)
+		b.handleVideoMessage(rootCtx, ctx)
 		return
 	case "audio":
-		handleAudioMessage(ctx)
+		b.handleAudioMessage(rootCtx, ctx)
 		return
 	}
 
+	if len(ctx.Text) > 0 && ctx.Text[0] == '-' && !ctx.IsGroup && b.isOwner(ctx.SenderID) {
+		b.handleOwnerDM(ctx)
+		return
+	}
+
+	// Every chat is stored, but only whitelisted ones get commands, replies and audio.
+	allowed := b.isChatAllowed(rootCtx, ctx)
+
 	if len(ctx.Text) > 0 && ctx.Text[0] == '-' && ctx.IsGroup == true {
+		// The owner can still whitelist a group from inside it.
+		if !allowed && !(b.isOwner(ctx.SenderID) && strings.Fields(ctx.Text)[0] == "--whitelist") {
+			return
+		}
 		fmt.Print("Command triggered with -!\n")
-		handleCommands(ctx)
+		b.handleCommands(ctx)
 		return
 	}
 
-	handleTextMessage(ctx)
+	b.handleTextMessage(rootCtx, ctx, allowed)
 }
 
 // ? -----------------------------------------------------------------------------------------------------
@@ -54,23 +102,23 @@ func splitMessages(ctx *MessageContext) {
 // ? -----------------------------------------------------------------------------------------------------
 
 // handleImageMessage handles incoming image messages.
-func handleImageMessage(ctx *MessageContext) {
-	description, err := isImageCached(GlobalImageDescriptionCache, ctx.MediaMeta.Hash, GlobalAppDB)
+func (b *Bot) handleImageMessage(rootCtx context.Context, ctx *MessageContext) {
+	description, err := isImageCached(b.ImageDescriptionCache, ctx.MediaMeta.Hash, b.DB)
 	isCacheMiss := err != nil
 
 	if isCacheMiss {
 		description = "Processing image..."
-		_ = setNewImageCache(GlobalImageDescriptionCache, ctx.MediaMeta.Hash, description, GlobalAppDB)
+		_ = setNewImageCache(b.ImageDescriptionCache, ctx.MediaMeta.Hash, description, b.DB)
 	} else {
 		description = "I was found in cache :D" // ! delete later, this should do nothing, this is for testing purposes
 	}
 
-	err = GlobalAppDB.InsertMessageContext(
-		context.Background(),
+	err = b.DB.InsertMessageContext(
+		rootCtx,
 		ctx.MessageID,
 		ctx.ChatID.String(),
-		ctx.SenderName,
-		ctx.SenderID.String(),
+		b.senderName(ctx),
+		b.senderJID(ctx),
 		&description,
 		nil,
 		&ctx.Timestamp,
@@ -81,32 +129,35 @@ func handleImageMessage(ctx *MessageContext) {
 	}
 
 	if isCacheMiss {
-		go func(msgID string, imgHash string) {
-			time.Sleep(10 * time.Second)                  // TODO: This is a Dummy, implement API
+		msgID, imgHash := ctx.MessageID, ctx.MediaMeta.Hash
+		b.Shutdown.Go(func(jobCtx context.Context) {
+			if !sleepContext(jobCtx, 10*time.Second) { // TODO: This is a Dummy, implement API
+				return
+			}
 			apiResponse := "Hi, I'm the api, I'm so cool" // ! Dummy API responce
 
-			_ = setNewImageCache(GlobalImageDescriptionCache, imgHash, apiResponse, GlobalAppDB)
+			_ = setNewImageCache(b.ImageDescriptionCache, imgHash, apiResponse, b.DB)
 
-			err := GlobalAppDB.UpdateMessageContextMediaDescription(context.Background(), msgID, apiResponse)
+			err := b.DB.UpdateMessageContextMediaDescription(jobCtx, msgID, apiResponse)
 			if err != nil {
 				fmt.Printf("Failed to update description: %v\n", err)
 			}
-		}(ctx.MessageID, ctx.MediaMeta.Hash)
+		})
 	}
 }
 
 // handleVideoMessage handles incoming video messages.
-func handleVideoMessage(ctx *MessageContext) {
+func (b *Bot) handleVideoMessage(rootCtx context.Context, ctx *MessageContext) {
 	fmt.Print("VIDEO DETECTED\n")
 
 	description := "Processing video..."
 
-	err := GlobalAppDB.InsertMessageContext(
-		context.Background(),
+	err := b.DB.InsertMessageContext(
+		rootCtx,
 		ctx.MessageID,
 		ctx.ChatID.String(),
-		ctx.SenderName,
-		ctx.SenderID.String(),
+		b.senderName(ctx),
+		b.senderJID(ctx),
 		&description,
 		nil,
 		&ctx.Timestamp,
@@ -116,29 +167,32 @@ func handleVideoMessage(ctx *MessageContext) {
 		return
 	}
 
-	go func(msgID string) {
-		time.Sleep(10 * time.Second)                     // Dummy: simulate processing
+	msgID := ctx.MessageID
+	b.Shutdown.Go(func(jobCtx context.Context) {
+		if !sleepContext(jobCtx, 10*time.Second) { // Dummy: simulate processing
+			return
+		}
 		apiResponse := "Omg I am the video api, umazing" // Dummy API response
 
-		err := GlobalAppDB.UpdateMessageContextMediaDescription(context.Background(), msgID, apiResponse)
+		err := b.DB.UpdateMessageContextMediaDescription(jobCtx, msgID, apiResponse)
 		if err != nil {
 			fmt.Printf("Failed to update video description: %v\n", err)
 		}
-	}(ctx.MessageID)
+	})
 }
 
 // handleAudioMessage handles incoming audio messages.
-func handleAudioMessage(ctx *MessageContext) {
+func (b *Bot) handleAudioMessage(rootCtx context.Context, ctx *MessageContext) {
 	fmt.Print("AUDIO DETECTED\n")
 
 	description := "Processing audio..."
 
-	err := GlobalAppDB.InsertMessageContext(
-		context.Background(),
+	err := b.DB.InsertMessageContext(
+		rootCtx,
 		ctx.MessageID,
 		ctx.ChatID.String(),
-		ctx.SenderName,
-		ctx.SenderID.String(),
+		b.senderName(ctx),
+		b.senderJID(ctx),
 		&description,
 		nil,
 		&ctx.Timestamp,
@@ -148,39 +202,41 @@ func handleAudioMessage(ctx *MessageContext) {
 		return
 	}
 
-	go func(msgID string) {
-		time.Sleep(10 * time.Second)                   // Dummy: simulate processing
+	msgID := ctx.MessageID
+	b.Shutdown.Go(func(jobCtx context.Context) {
+		if !sleepContext(jobCtx, 10*time.Second) { // Dummy: simulate processing
+			return
+		}
 		apiResponse := "Yooo, even audios?? fantastic" // Dummy API response
 
-		err := GlobalAppDB.UpdateMessageContextMediaDescription(context.Background(), msgID, apiResponse)
+		err := b.DB.UpdateMessageContextMediaDescription(jobCtx, msgID, apiResponse)
 		if err != nil {
 			fmt.Printf("Failed to update audio description: %v\n", err)
 		}
-	}(ctx.MessageID)
+	})
 }
 
 // ? -----------------------------------------------------------------------------------------------------
 // ? ----------------------------------------------Text Handlers------------------------------------------
 // ? -----------------------------------------------------------------------------------------------------
 
-func handleTextMessage(ctx *MessageContext) {
-	selfID := GlobalClient.Store.LID.User + "@lid"
+// handleTextMessage stores a text message and, where allowed, answers mentions
+// and plays audio triggers.
+func (b *Bot) handleTextMessage(rootCtx context.Context, ctx *MessageContext, allowed bool) {
+	if allowed && ctx.Timestamp.After(b.StartTime) && b.addressedToBot(ctx) {
+		b.replyToMention(ctx)
+	}
 
-	if ctx.Timestamp.After(BotStartTime) {
-		for _, mention := range ctx.Mentions {
-			if mention == selfID {
-				SendTextMessage(GlobalClient, ctx.ChatID, "Soy ese") // TODO: Send random sticker
-				break
-			}
-		}
+	if allowed && ctx.IsGroup && ctx.Timestamp.After(b.StartTime) && b.featureEnabled(featureAudio) {
+		b.playAudioTriggers(ctx)
 	}
 
-	err := GlobalAppDB.InsertMessageContext(
-		context.Background(),
+	err := b.DB.InsertMessageContext(
+		rootCtx,
 		ctx.MessageID,
 		ctx.ChatID.String(),
-		ctx.SenderName,
-		ctx.SenderID.String(),
+		b.senderName(ctx),
+		b.senderJID(ctx),
 		nil, // media description (nil for text)
 		&ctx.Text,
 		&ctx.Timestamp,
@@ -191,8 +247,8 @@ func handleTextMessage(ctx *MessageContext) {
 
 }
 
-func handleCommands(ctx *MessageContext) {
-	if ctx.Timestamp.Before(BotStartTime) {
+func (b *Bot) handleCommands(ctx *MessageContext) {
+	if ctx.Timestamp.Before(b.StartTime) {
 		return
 	}
 
@@ -201,35 +257,62 @@ func handleCommands(ctx *MessageContext) {
 	switch words[0] {
 	case "-s", "--summarize":
 		fmt.Println("Summarize command detected!")
-		// TODO: IMPLEMENT SUMMARIZE LOGIC
+		if !b.featureEnabled(featureSummaries) {
+			b.Messenger.SendReplyMessage(ctx, "Summaries are turned off.")
+			break
+		}
+		b.handleSummarizeCommand(ctx, words)
 
 	// ? ===================================
 	case "-v", "--version":
-		SendTextMessage(GlobalClient, ctx.ChatID, GlobalPromptsConfig.VersionString)
+		b.Messenger.SendTextMessage(ctx.ChatID, b.Prompts().VersionString)
 
 	// ? ===================================
 	case "-i", "--info":
-		SendTextMessage(GlobalClient, ctx.ChatID, GlobalPromptsConfig.InfoString)
+		b.Messenger.SendTextMessage(ctx.ChatID, b.Prompts().InfoString)
+
+	// ? ===================================
+	case "--status":
+		status := fmt.Sprintf("*Uptime:* %s\n*Connection:* %s", time.Since(b.StartTime).Round(time.Second), b.Supervisor.Status())
+		b.Messenger.SendTextMessage(ctx.ChatID, status)
 
 	// ? ===================================
 	case "--whitelist":
-		ownerJID, err := types.ParseJID(GlobalConfig.OwnerLID)
-		if err != nil {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Owner not configured correctly.")
+		if _, err := types.ParseJID(b.Config().OwnerLID); err != nil {
+			b.Messenger.SendTextMessage(ctx.ChatID, "Owner not configured correctly.")
 			break
 		}
-		if ctx.SenderID != ownerJID {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Only the owner can whitelist.")
+		if !b.isOwner(ctx.SenderID) {
+			b.Messenger.SendTextMessage(ctx.ChatID, "Only the owner can whitelist.")
 			fmt.Printf("%s tried to whitelist!\n", ctx.SenderName)
 			break
 		}
 
-		// TODO: implement whitelist in db
-		fmt.Println("Whitelist command issued by owner.")
+		rootCtx := b.Shutdown.Context()
+		if err := b.DB.AddGroupToWhitelist(rootCtx, ctx.ChatID.String()); err != nil {
+			fmt.Printf("Failed to whitelist %s: %v\n", ctx.ChatID, err)
+			b.Messenger.SendTextMessage(ctx.ChatID, "Failed to whitelist this group.")
+			break
+		}
+		// Whitelisting answers a pending invite too.
+		if _, err := b.DB.RemovePendingGroup(rootCtx, ctx.ChatID.String()); err != nil {
+			fmt.Printf("Failed to clear pending group %s: %v\n", ctx.ChatID, err)
+		}
+		b.Messenger.SendTextMessage(ctx.ChatID, "This group is whitelisted now.")
+
+	// ? ===================================
+	case "--quota":
+		b.handleQuotaCommand(ctx, words)
+
+	case "--usage":
+		b.handleUsageCommand(ctx, words)
 
 	// ? ===================================
 	case "--alias":
-		handleAliasCommand(ctx, words)
+		b.handleAliasCommand(ctx, words)
+
+	case "--aliases":
+		b.handleAliasesCommand(ctx)
 
 	// ? ===================================
 	case "--disable":
@@ -240,60 +323,56 @@ func handleCommands(ctx *MessageContext) {
 
 	// ? ===================================
 	case "--reload-json":
-		ownerJID, err := types.ParseJID(GlobalConfig.OwnerLID)
-		if err != nil {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Owner not configured correctly.")
+		if _, err := types.ParseJID(b.Config().OwnerLID); err != nil {
+			b.Messenger.SendTextMessage(ctx.ChatID, "Owner not configured correctly.")
 			break
 		}
-		if ctx.SenderID != ownerJID {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Only the owner can reload configs.")
+		if !b.isOwner(ctx.SenderID) {
+			b.Messenger.SendTextMessage(ctx.ChatID, "Only the owner can reload configs.")
 			break
 		}
-		err = reloadConfigs()
-		if err != nil {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Failed to reload configs: "+err.Error())
+		if err := b.ReloadConfigs(); err != nil {
+			b.Messenger.SendTextMessage(ctx.ChatID, "Failed to reload configs: "+err.Error())
 		} else {
-			SendTextMessage(GlobalClient, ctx.ChatID, "Configs reloaded successfully.")
+			b.Messenger.SendTextMessage(ctx.ChatID, "Configs reloaded successfully.")
 		}
 	}
 }
 
-// ? ----------------------------------------------Alias Handler----------------------------------------------
-func handleAliasCommand(ctx *MessageContext, words []string) {
-	if GlobalAppDB == nil {
-		SendTextMessage(GlobalClient, ctx.ChatID, "Database not initialized.")
-		return
-	}
-	if len(words) < 2 {
-		SendTextMessage(GlobalClient, ctx.ChatID, "Usage: --alias <name>")
+// replyToMention answers a message addressed to the bot. A question gets an LLM
+// reply; a bare mention, or one naming only a sticker mood, gets a sticker.
+// Both are sent from a background job.
+func (b *Bot) replyToMention(ctx *MessageContext) {
+	question := stripMentions(ctx.Text, b.identity().Users())
+	bare := question == "" || (b.Stickers.MoodIn(question) != "" && len(strings.Fields(question)) == 1)
+	if b.LLM != nil && !bare && b.featureEnabled(featureChat) {
+		b.handleConversation(ctx, question)
 		return
 	}
 
-	chatJID := ctx.ChatID.String()
-	senderJID := ctx.SenderID.String()
-	alias := words[1]
+	b.Shutdown.Go(func(jobCtx context.Context) {
+		work := b.startWork(ctx)
 
-	if err := setNewAliasCache(GlobalAliasCache, chatJID, senderJID, alias, GlobalAppDB); err != nil {
-		SendReplyMessage(GlobalClient, ctx, "Failed to save alias")
-		return
-	}
-
-	SendReplyMessage(GlobalClient, ctx, "Alias has been saved.")
+		stickers := b.featureEnabled(featureStickers)
+		var err error
+		if stickers {
+			err = b.sendSticker(jobCtx, ctx.ChatID, b.Stickers.MoodIn(ctx.Text))
+			if err != nil && b.Stickers.Len() > 0 {
+				fmt.Printf("Failed to send sticker: %v\n", err)
+			}
+		}
+		if !stickers || err != nil {
+			err = b.Messenger.SendTextMessage(ctx.ChatID, "Soy ese")
+		}
+		work.Done(err == nil)
+	})
 }
 
-// ? ----------------------------------------------Config Handler----------------------------------------------
-func reloadConfigs() error {
-	var err error
-
-	GlobalConfig, err = ReadConfig("config.json")
-	if err != nil {
-		return err
-	}
-
-	GlobalPromptsConfig, err = ReadPromptsConfig("prompts.json")
+// senderAlias returns the alias stored for the sender in this chat, or "" if there is none.
+func (b *Bot) senderAlias(ctx *MessageContext) string {
+	alias, err := isAliasCached(b.AliasCache, ctx.ChatID.String(), b.senderJID(ctx), b.DB)
 	if err != nil {
-		return err
+		return ""
 	}
-
-	return nil
+	return alias
 }
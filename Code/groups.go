@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// groupMetaTTL is how long stored group info is trusted before asking WhatsApp
+// again. Events keep it current in between, but can be missed while offline.
+const groupMetaTTL = 6 * time.Hour
+
+// systemSenderName is the sender of the join/leave lines added to the message context.
+const systemSenderName = "Group"
+
+// groupMetaFromInfo converts whatsmeow's group info, with participants by canonical JID.
+func (b *Bot) groupMetaFromInfo(info *types.GroupInfo, at time.Time) GroupMeta {
+	meta := GroupMeta{
+		ChatJID:   info.JID.String(),
+		Name:      info.Name,
+		Topic:     info.Topic,
+		UpdatedAt: at,
+	}
+	for _, participant := range info.Participants {
+		jid := participant.JID
+		if !participant.LID.IsEmpty() {
+			jid = participant.LID
+		}
+		meta.Participants = append(meta.Participants, GroupMember{
+			JID:          b.canonicalJID(jid).String(),
+			IsAdmin:      participant.IsAdmin || participant.IsSuperAdmin,
+			IsSuperAdmin: participant.IsSuperAdmin,
+		})
+	}
+	return meta
+}
+
+// refreshGroup asks WhatsApp for a group's info and stores it.
+func (b *Bot) refreshGroup(ctx context.Context, chat types.JID) (*GroupMeta, error) {
+	client := b.Client()
+	if client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	info, err := client.GetGroupInfo(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	meta := b.groupMetaFromInfo(info, time.Now())
+	if err := b.DB.SaveGroup(ctx, meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// groupMeta returns what's known about a group, refreshing it from WhatsApp when
+// it's missing or older than groupMetaTTL. Stale data beats none if that fails.
+func (b *Bot) groupMeta(ctx context.Context, chat types.JID) (*GroupMeta, error) {
+	meta, ok, err := b.DB.GetGroup(ctx, chat.String())
+	if err != nil {
+		return nil, err
+	}
+	if ok && (time.Since(meta.UpdatedAt) < groupMetaTTL || b.Client() == nil) {
+		return meta, nil
+	}
+
+	fresh, err := b.refreshGroup(ctx, chat)
+	if err != nil {
+		if ok {
+			fmt.Printf("Failed to refresh group %s, using stored info: %v\n", chat, err)
+			return meta, nil
+		}
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// groupName returns the group's subject, or "" if it isn't known.
+func (b *Bot) groupName(ctx context.Context, chat types.JID) string {
+	meta, err := b.groupMeta(ctx, chat)
+	if err != nil {
+		fmt.Printf("Failed to get group info for %s: %v\n", chat, err)
+		return ""
+	}
+	return meta.Name
+}
+
+// isGroupAdmin reports whether jid is an admin of group.
+func (b *Bot) isGroupAdmin(ctx context.Context, group, jid types.JID) bool {
+	meta, err := b.groupMeta(ctx, group)
+	if err != nil {
+		fmt.Printf("Failed to get group info for %s: %v\n", group, err)
+		return false
+	}
+	want := b.canonicalJID(jid).String()
+	for _, member := range meta.Participants {
+		if member.JID == want {
+			return member.IsAdmin
+		}
+	}
+	return false
+}
+
+// memberName names someone in a chat the way stored messages do.
+func (b *Bot) memberName(chat, jid types.JID) string {
+	if alias, err := isAliasCached(b.AliasCache, chat.String(), b.canonicalJID(jid).String(), b.DB); err == nil && alias != "" {
+		return alias
+	}
+	return b.displayName(jid, "")
+}
+
+// ? ----------------------------------------------Events----------------------------------------------
+
+// handleJoinedGroup stores the info of a group the bot was just added to, then
+// greets it or asks the owner about it.
+func (b *Bot) handleJoinedGroup(ctx context.Context, evt *events.JoinedGroup) {
+	if err := b.DB.SaveGroup(ctx, b.groupMetaFromInfo(&evt.GroupInfo, time.Now())); err != nil {
+		fmt.Printf("Failed to save joined group %s: %v\n", evt.JID, err)
+	}
+	b.onboardGroup(ctx, evt)
+}
+
+// handleGroupInfo applies a group change to the stored info and records joins,
+// leaves and renames in the message context so summaries can mention them.
+// A group the bot itself left or was removed from is forgotten instead.
+func (b *Bot) handleGroupInfo(ctx context.Context, evt *events.GroupInfo) {
+	chat := evt.JID.String()
+	for _, jid := range evt.Leave {
+		if b.identity().IsSelf(jid) {
+			if err := b.DB.DeleteGroup(ctx, chat); err != nil {
+				fmt.Printf("Failed to forget group %s: %v\n", chat, err)
+			}
+			return
+		}
+	}
+	var actor types.JID
+	if evt.Sender != nil {
+		actor = *evt.Sender
+	}
+
+	if evt.Name != nil || evt.Topic != nil {
+		var name, topic *string
+		if evt.Name != nil {
+			name = &evt.Name.Name
+		}
+		if evt.Topic != nil {
+			topic = &evt.Topic.Topic
+		}
+		if err := b.DB.UpdateGroupDetails(ctx, chat, name, topic); err != nil {
+			fmt.Printf("Failed to update group %s: %v\n", chat, err)
+		}
+	}
+	if evt.Name != nil && !actor.IsEmpty() {
+		b.recordGroupEvent(ctx, evt, actor, fmt.Sprintf("%s renamed the group to %q", b.memberName(evt.JID, actor), evt.Name.Name))
+	}
+
+	if err := b.DB.AddGroupParticipants(ctx, chat, b.canonicalStrings(evt.Join)); err != nil {
+		fmt.Printf("Failed to record joins in %s: %v\n", chat, err)
+	}
+	for _, jid := range evt.Join {
+		text := b.memberName(evt.JID, jid) + " joined"
+		if !actor.IsEmpty() && b.canonicalJID(actor) != b.canonicalJID(jid) {
+			text = b.memberName(evt.JID, actor) + " added " + b.memberName(evt.JID, jid)
+		}
+		b.recordGroupEvent(ctx, evt, jid, text)
+	}
+
+	if err := b.DB.RemoveGroupParticipants(ctx, chat, b.canonicalStrings(evt.Leave)); err != nil {
+		fmt.Printf("Failed to record leaves in %s: %v\n", chat, err)
+	}
+	for _, jid := range evt.Leave {
+		text := b.memberName(evt.JID, jid) + " left"
+		if !actor.IsEmpty() && b.canonicalJID(actor) != b.canonicalJID(jid) {
+			text = b.memberName(evt.JID, actor) + " removed " + b.memberName(evt.JID, jid)
+		}
+		b.recordGroupEvent(ctx, evt, jid, text)
+	}
+
+	if err := b.DB.SetGroupAdmins(ctx, chat, b.canonicalStrings(evt.Promote), true); err != nil {
+		fmt.Printf("Failed to record promotions in %s: %v\n", chat, err)
+	}
+	if err := b.DB.SetGroupAdmins(ctx, chat, b.canonicalStrings(evt.Demote), false); err != nil {
+		fmt.Printf("Failed to record demotions in %s: %v\n", chat, err)
+	}
+}
+
+// recordGroupEvent adds a system line to the group's message context.
+func (b *Bot) recordGroupEvent(ctx context.Context, evt *events.GroupInfo, subject types.JID, text string) {
+	timestamp := evt.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	// Group events have no message ID, so make a stable one.
+	id := fmt.Sprintf("group-event:%s:%d:%s", evt.JID.User, timestamp.UnixNano(), subject.User)
+	if err := b.DB.InsertMessageContext(ctx, id, evt.JID.String(), systemSenderName, "", nil, &text, &timestamp); err != nil {
+		fmt.Printf("Failed to record group event: %v\n", err)
+	}
+}
+
+func (b *Bot) canonicalStrings(jids []types.JID) []string {
+	out := make([]string, 0, len(jids))
+	for _, jid := range jids {
+		out = append(out, b.canonicalJID(jid).String())
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const defaultOnboardingMessage = "Hi! I'm Bancho. Send --info to see what I can do."
+
+// isGroupAllowed reports whether a group is whitelisted in the config or the database.
+func (b *Bot) isGroupAllowed(ctx context.Context, chat types.JID) (bool, error) {
+	if slices.Contains(b.Config().GroupWhitelist, chat.String()) {
+		return true, nil
+	}
+	return b.DB.IsGroupWhitelisted(ctx, chat.String())
+}
+
+// isUserAllowed reports whether someone may talk to the bot in a DM: the owner,
+// or a user whitelisted in the config or the database.
+func (b *Bot) isUserAllowed(ctx context.Context, user types.JID) (bool, error) {
+	if b.isOwner(user) {
+		return true, nil
+	}
+	canonical := b.canonicalJID(user)
+	for _, entry := range b.Config().UserWhitelist {
+		if jid, err := types.ParseJID(entry); err == nil && b.canonicalJID(jid) == canonical {
+			return true, nil
+		}
+	}
+	return b.DB.IsUserWhitelisted(ctx, canonical.String())
+}
+
+// isChatAllowed reports whether the bot answers in the chat of msg: whitelisted
+// groups, and DMs from allowed users. Messages elsewhere are only stored.
+func (b *Bot) isChatAllowed(ctx context.Context, msg *MessageContext) bool {
+	var allowed bool
+	var err error
+	if msg.IsGroup {
+		allowed, err = b.isGroupAllowed(ctx, msg.ChatID)
+	} else {
+		allowed, err = b.isUserAllowed(ctx, msg.SenderID)
+	}
+	if err != nil {
+		fmt.Printf("Failed to check whitelist for %s: %v\n", msg.ChatID, err)
+		return false
+	}
+	return allowed
+}
+
+// LeaveUnapprovedAfter returns how long the bot stays in a group nobody approved, or 0 for forever.
+func (c *Config) LeaveUnapprovedAfter() time.Duration {
+	return time.Duration(max(c.LeaveUnapprovedAfterMinutes, 0)) * time.Minute
+}
+
+func (b *Bot) sendOnboarding(chat types.JID) {
+	message := b.Prompts().OnboardingMessage
+	if message == "" {
+		message = defaultOnboardingMessage
+	}
+	if err := b.Messenger.SendTextMessage(chat, message); err != nil {
+		fmt.Printf("Failed to send onboarding message to %s: %v\n", chat, err)
+	}
+}
+
+// notifyOwner sends the owner a direct message.
+func (b *Bot) notifyOwner(message string) {
+	owner, err := types.ParseJID(b.Config().OwnerLID)
+	if err != nil || owner.IsEmpty() {
+		fmt.Printf("No owner to notify: %s\n", message)
+		return
+	}
+	if err := b.Messenger.SendTextMessage(owner, message); err != nil {
+		fmt.Printf("Failed to notify owner: %v\n", err)
+	}
+}
+
+// onboardGroup greets a group the bot was added to if it's whitelisted (or the
+// owner added it), and otherwise asks the owner whether to stay.
+func (b *Bot) onboardGroup(ctx context.Context, evt *events.JoinedGroup) {
+	chat := evt.JID
+	allowed, err := b.isGroupAllowed(ctx, chat)
+	if err != nil {
+		fmt.Printf("Failed to check whitelist for %s: %v\n", chat, err)
+		return
+	}
+	if !allowed && evt.Sender != nil && b.isOwner(*evt.Sender) {
+		if err := b.DB.AddGroupToWhitelist(ctx, chat.String()); err != nil {
+			fmt.Printf("Failed to whitelist %s: %v\n", chat, err)
+			return
+		}
+		allowed = true
+	}
+	if allowed {
+		b.sendOnboarding(chat)
+		return
+	}
+
+	var deadline time.Time
+	if after := b.Config().LeaveUnapprovedAfter(); after > 0 {
+		deadline = time.Now().Add(after)
+	}
+	if err := b.DB.AddPendingGroup(ctx, chat.String(), deadline); err != nil {
+		fmt.Printf("Failed to record pending group %s: %v\n", chat, err)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("I was added to *%s* (%s)", groupLabel(evt.Name, chat), chat.User))
+	if evt.Sender != nil {
+		sb.WriteString(" by " + b.displayName(*evt.Sender, ""))
+	}
+	sb.WriteString(fmt.Sprintf(", which isn't whitelisted.\n\nReply --approve %s or --deny %s.", chat.User, chat.User))
+	if !deadline.IsZero() {
+		sb.WriteString(fmt.Sprintf("\nI'll leave on my own in %s otherwise.", formatWait(time.Until(deadline))))
+	}
+	b.notifyOwner(sb.String())
+
+	if !deadline.IsZero() {
+		b.scheduleLeave(chat, deadline)
+	}
+}
+
+// scheduleLeave leaves chat at deadline unless it was approved or denied by then.
+// Shutting down drops the timer; resumePendingGroups sets it again on start.
+func (b *Bot) scheduleLeave(chat types.JID, deadline time.Time) {
+	b.Shutdown.After(time.Until(deadline), func(ctx context.Context) {
+		pending, err := b.DB.RemovePendingGroup(ctx, chat.String())
+		if err != nil || !pending {
+			return
+		}
+		// Approved just now, before its pending row was cleared.
+		if allowed, err := b.isGroupAllowed(ctx, chat); err != nil || allowed {
+			return
+		}
+		label := groupLabel(b.groupName(ctx, chat), chat)
+		if err := b.Messenger.LeaveGroup(chat); err != nil {
+			fmt.Printf("Failed to leave unapproved group %s: %v\n", chat, err)
+			return
+		}
+		if err := b.DB.DeleteGroup(ctx, chat.String()); err != nil {
+			fmt.Printf("Failed to forget group %s: %v\n", chat, err)
+		}
+		b.notifyOwner(fmt.Sprintf("Nobody approved *%s*, so I left it.", label))
+	})
+}
+
+// resumePendingGroups reschedules the timeouts of groups still waiting for
+// approval, e.g. after a restart.
+func (b *Bot) resumePendingGroups(ctx context.Context) {
+	pending, err := b.DB.ListPendingGroups(ctx)
+	if err != nil {
+		fmt.Printf("Failed to load pending groups: %v\n", err)
+		return
+	}
+	for _, group := range pending {
+		jid, err := types.ParseJID(group.ChatJID)
+		if err != nil || group.Deadline.IsZero() {
+			continue
+		}
+		b.scheduleLeave(jid, group.Deadline)
+	}
+}
+
+// noticeUnlistedGroups tells the owner, once per account, which groups the bot
+// is in without being whitelisted. Those went quiet when the whitelist started
+// applying to commands, and nothing else would tell the owner why.
+func (b *Bot) noticeUnlistedGroups(ctx context.Context) {
+	account := b.DB.Account()
+	if account == "" {
+		return
+	}
+	migration := "whitelist-notice:" + account
+	if done, err := b.DB.MigrationApplied(ctx, migration); err != nil || done {
+		return
+	}
+	joined, err := b.Messenger.JoinedGroups()
+	if err != nil {
+		fmt.Printf("Failed to fetch joined groups: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	var lines []string
+	for _, info := range joined {
+		// Stored so the owner can --whitelist them by name.
+		if err := b.DB.SaveGroup(ctx, b.groupMetaFromInfo(info, now)); err != nil {
+			fmt.Printf("Failed to save group %s: %v\n", info.JID, err)
+		}
+		if allowed, err := b.isGroupAllowed(ctx, info.JID); err != nil || allowed {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", groupLabel(info.Name, info.JID), info.JID.User))
+	}
+
+	if first, err := b.DB.RecordMigration(ctx, migration); err != nil || !first {
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+	b.notifyOwner(fmt.Sprintf("I only answer in whitelisted groups, and these aren't:\n%s\n\nSend --whitelist <group> here, or --whitelist inside the group.", strings.Join(lines, "\n")))
+}
+
+func groupLabel(name string, chat types.JID) string {
+	if name != "" {
+		return name
+	}
+	return chat.User
+}
+
+// ? ----------------------------------------------Approval----------------------------------------------
+
+// handleApproveCommand whitelists and greets a pending group, or leaves it.
+// The group can be left out when only one is pending. It stays pending if
+// that fails, so the owner can try again.
+func (b *Bot) handleApproveCommand(ctx *MessageContext, words []string, approve bool) {
+	rootCtx := context.Background()
+
+	pending, err := b.DB.ListPendingGroups(rootCtx)
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, "Failed to load pending groups.")
+		return
+	}
+
+	var chat types.JID
+	if len(words) > 1 {
+		arg := words[1]
+		if !strings.Contains(arg, "@") {
+			arg += "@" + types.GroupServer
+		}
+		jid, err := types.ParseJID(arg)
+		if err != nil {
+			b.Messenger.SendReplyMessage(ctx, "That's not a group ID.")
+			return
+		}
+		chat = jid
+		if !slices.ContainsFunc(pending, func(group PendingGroup) bool { return group.ChatJID == chat.String() }) {
+			b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("%s isn't waiting for approval, see --groups.", chat.User))
+			return
+		}
+	} else {
+		if len(pending) != 1 {
+			b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("%d groups are waiting, say which: %s <group id>", len(pending), words[0]))
+			return
+		}
+		chat, _ = types.ParseJID(pending[0].ChatJID)
+	}
+
+	label := groupLabel(b.groupName(rootCtx, chat), chat)
+
+	if approve {
+		if err := b.DB.AddGroupToWhitelist(rootCtx, chat.String()); err != nil {
+			fmt.Printf("Failed to whitelist %s: %v\n", chat, err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to whitelist the group.")
+			return
+		}
+		if _, err := b.DB.RemovePendingGroup(rootCtx, chat.String()); err != nil {
+			fmt.Printf("Failed to clear pending group %s: %v\n", chat, err)
+		}
+		b.sendOnboarding(chat)
+		b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Approved *%s*.", label))
+		return
+	}
+
+	if err := b.Messenger.LeaveGroup(chat); err != nil {
+		fmt.Printf("Failed to leave %s: %v\n", chat, err)
+		b.Messenger.SendReplyMessage(ctx, "Failed to leave the group.")
+		return
+	}
+	if err := b.DB.DeleteGroup(rootCtx, chat.String()); err != nil {
+		fmt.Printf("Failed to forget group %s: %v\n", chat, err)
+	}
+	b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Left *%s*.", label))
+}
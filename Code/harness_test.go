@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	testOwner  = types.NewJID("100000000000001", types.HiddenUserServer)
+	testMember = types.NewJID("100000000000002", types.HiddenUserServer)
+	testGroup  = types.NewJID("120363000000000001", types.GroupServer)
+
+	// The bot's own phone number and LID.
+	testBotPN  = types.NewJID("5215500000000", types.DefaultUserServer)
+	testBotLID = types.NewJID("100000000000099", types.HiddenUserServer)
+)
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------Fake Messenger-----------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+// sentMessage is one outbound message captured by fakeMessenger.
+type sentMessage struct {
+	ID      types.MessageID
+	Chat    types.JID
+	Text    string          // latest text, after any edits
+	ReplyTo types.MessageID // empty unless sent with SendReplyMessage
+	Edits   int
+}
+
+// fakeMessenger records everything the bot tries to send instead of talking to WhatsApp.
+// Edits are applied in place, so sent shows what the chat would look like.
+type fakeMessenger struct {
+	mu     sync.Mutex
+	sent   []sentMessage
+	nextID int
+
+	typing    []bool
+	online    []bool
+	read      []types.MessageID
+	reactions map[types.MessageID][]string // every reaction set on a message, in order
+	uploads   int
+	left      []types.JID
+	// failAfter makes every send fail once this many messages went out; 0 never fails.
+	failAfter int
+	groups    []*types.GroupInfo // what JoinedGroups returns
+}
+
+func (f *fakeMessenger) add(msg sentMessage) (types.MessageID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failAfter > 0 && f.nextID >= f.failAfter {
+		return "", fmt.Errorf("send failed")
+	}
+	f.nextID++
+	msg.ID = types.MessageID(fmt.Sprintf("BOTMSG%05d", f.nextID))
+	f.sent = append(f.sent, msg)
+	return msg.ID, nil
+}
+
+func (f *fakeMessenger) SendTextMessage(chatJID types.JID, message string) error {
+	_, err := f.add(sentMessage{Chat: chatJID, Text: message})
+	return err
+}
+
+func (f *fakeMessenger) SendReplyMessage(messageContext *MessageContext, message string) error {
+	_, err := f.add(sentMessage{Chat: messageContext.ChatID, Text: message, ReplyTo: messageContext.MessageID})
+	return err
+}
+
+func (f *fakeMessenger) SendReplyMessageID(messageContext *MessageContext, message string) (types.MessageID, error) {
+	return f.add(sentMessage{Chat: messageContext.ChatID, Text: message, ReplyTo: messageContext.MessageID})
+}
+
+func (f *fakeMessenger) EditMessage(chatJID types.JID, messageID types.MessageID, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.sent {
+		if f.sent[i].ID == messageID && f.sent[i].Chat == chatJID {
+			f.sent[i].Text = message
+			f.sent[i].Edits++
+			return nil
+		}
+	}
+	return fmt.Errorf("no message %s in %s", messageID, chatJID)
+}
+
+func (f *fakeMessenger) SetTyping(chatJID types.JID, typing bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.typing = append(f.typing, typing)
+	return nil
+}
+
+func (f *fakeMessenger) SetOnline(online bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.online = append(f.online, online)
+	return nil
+}
+
+func (f *fakeMessenger) MarkRead(messageContext *MessageContext) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.read = append(f.read, messageContext.MessageID)
+	return nil
+}
+
+func (f *fakeMessenger) React(messageContext *MessageContext, emoji string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.reactions == nil {
+		f.reactions = make(map[types.MessageID][]string)
+	}
+	f.reactions[messageContext.MessageID] = append(f.reactions[messageContext.MessageID], emoji)
+	return nil
+}
+
+func (f *fakeMessenger) Upload(data []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads++
+	sum := sha256.Sum256(data)
+	return &whatsmeow.UploadResponse{
+		URL:           fmt.Sprintf("https://mmg.example/%d", f.uploads),
+		DirectPath:    fmt.Sprintf("/v/%d", f.uploads),
+		MediaKey:      []byte("key"),
+		FileEncSHA256: sum[:],
+		FileSHA256:    sum[:],
+		FileLength:    uint64(len(data)),
+	}, nil
+}
+
+func (f *fakeMessenger) SendSticker(chatJID types.JID, sticker *whatsmeow.UploadResponse, width, height int) error {
+	_, err := f.add(sentMessage{Chat: chatJID, Text: fmt.Sprintf("[sticker %s %dx%d]", sticker.URL, width, height)})
+	return err
+}
+
+func (f *fakeMessenger) SendAudio(chatJID types.JID, audio *whatsmeow.UploadResponse, mimetype string) error {
+	_, err := f.add(sentMessage{Chat: chatJID, Text: "[audio " + mimetype + "]"})
+	return err
+}
+
+func (f *fakeMessenger) LeaveGroup(chatJID types.JID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.left = append(f.left, chatJID)
+	return nil
+}
+
+func (f *fakeMessenger) JoinedGroups() ([]*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.groups, nil
+}
+
+// Left returns the groups the bot left, in order.
+func (f *fakeMessenger) Left() []types.JID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]types.JID(nil), f.left...)
+}
+
+func (f *fakeMessenger) Uploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.uploads
+}
+
+// fakeLIDs maps phone-number JIDs to LIDs like whatsmeow's LID store.
+type fakeLIDs map[types.JID]types.JID
+
+func (f fakeLIDs) GetLIDForPN(ctx context.Context, pn types.JID) (types.JID, error) {
+	return f[pn], nil
+}
+
+func (f fakeLIDs) GetPNForLID(ctx context.Context, lid types.JID) (types.JID, error) {
+	for pn, l := range f {
+		if l == lid {
+			return pn, nil
+		}
+	}
+	return types.EmptyJID, nil
+}
+
+// fakeContacts stands in for whatsmeow's contact store.
+type fakeContacts map[types.JID]types.ContactInfo
+
+func (f fakeContacts) GetContact(ctx context.Context, user types.JID) (types.ContactInfo, error) {
+	return f[user], nil
+}
+
+// fakeLLM answers every request with Reply (or fails with Err) and keeps the
+// requests for inspection.
+type fakeLLM struct {
+	mu       sync.Mutex
+	Reply    string
+	Err      error
+	requests []ChatRequest
+}
+
+func (f *fakeLLM) Complete(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return &ChatResponse{Content: f.Reply, Model: req.Model, PromptTokens: 100, CompletionTokens: 20}, nil
+}
+
+func (f *fakeLLM) Requests() []ChatRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ChatRequest(nil), f.requests...)
+}
+
+// ? -----------------------------------------------------------------------------------------------------
+// ? ----------------------------------------------Harness------------------------------------------------
+// ? -----------------------------------------------------------------------------------------------------
+
+// testHarness wires a Bot to a fake messenger and an in-memory database, and
+// builds synthetic events.Message values to push through eventHandler.
+type testHarness struct {
+	t         *testing.T
+	ctx       context.Context
+	bot       *Bot
+	messenger *fakeMessenger
+	llm       *fakeLLM
+
+	clock  time.Time
+	nextID int
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := OpenAppDB(ctx, "file::memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+
+	config := &Config{
+		Token:    "test-token",
+		OwnerLID: testOwner.String(),
+		// Most scenarios happen in testGroup; onboarding tests clear this.
+		GroupWhitelist: []string{testGroup.String()},
+	}
+	prompts := &PromptsConfig{
+		InfoString:        "test info",
+		VersionString:     "test version",
+		PersonalityPrompt: "test personality",
+		LengthShort:       "test short",
+		LengthMedium:      "test medium",
+		LengthLong:        "test long",
+		SummaryStyles: map[string]string{
+			"bullets": "test bullets",
+			"actions": "test actions",
+		},
+		DefaultSummaryStyle: "bullets",
+	}
+
+	messenger := &fakeMessenger{}
+	bot := NewBot(config, prompts, db, messenger)
+	bot.Shutdown = NewShutdownCoordinator(ctx)
+	llm := &fakeLLM{Reply: "test summary"}
+	bot.LLM = llm
+	bot.Self = NewIdentity(testBotPN, testBotLID)
+
+	t.Cleanup(func() {
+		// Cancels the dummy media jobs instead of waiting out their sleeps.
+		bot.Shutdown.StopIntake()
+		bot.Shutdown.Drain(0)
+		_ = db.Close()
+	})
+
+	return &testHarness{
+		t:         t,
+		ctx:       ctx,
+		bot:       bot,
+		messenger: messenger,
+		llm:       llm,
+		clock:     bot.StartTime,
+	}
+}
+
+// tick advances the fake clock so every message gets a distinct, post-start timestamp.
+func (h *testHarness) tick() time.Time {
+	h.clock = h.clock.Add(time.Second)
+	return h.clock
+}
+
+func (h *testHarness) newEvent(chat, sender types.JID, pushName string, msg *waProto.Message) *events.Message {
+	h.nextID++
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:    chat,
+				Sender:  sender,
+				IsGroup: chat.Server == types.GroupServer,
+			},
+			ID:        fmt.Sprintf("TESTMSG%05d", h.nextID),
+			PushName:  pushName,
+			Timestamp: h.tick(),
+		},
+		Message: msg,
+	}
+}
+
+// Text builds a plain text message, or an extended one when mentions are given.
+func (h *testHarness) Text(chat, sender types.JID, pushName, text string, mentions ...types.JID) *events.Message {
+	if len(mentions) == 0 {
+		return h.newEvent(chat, sender, pushName, &waProto.Message{Conversation: proto.String(text)})
+	}
+
+	mentioned := make([]string, len(mentions))
+	for i, jid := range mentions {
+		mentioned[i] = jid.String()
+	}
+	return h.newEvent(chat, sender, pushName, &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: &waProto.ContextInfo{MentionedJID: mentioned},
+		},
+	})
+}
+
+// Image builds an image message; mediaKey doubles as the image cache hash.
+func (h *testHarness) Image(chat, sender types.JID, pushName string, mediaKey []byte) *events.Message {
+	return h.newEvent(chat, sender, pushName, &waProto.Message{
+		ImageMessage: &waProto.ImageMessage{
+			Mimetype:   proto.String("image/jpeg"),
+			FileLength: proto.Uint64(1024),
+			MediaKey:   mediaKey,
+			Width:      proto.Uint32(640),
+			Height:     proto.Uint32(480),
+		},
+	})
+}
+
+// Deliver feeds events through the bot exactly like whatsmeow would.
+func (h *testHarness) Deliver(evts ...*events.Message) {
+	for _, evt := range evts {
+		h.bot.eventHandler(evt)
+	}
+}
+
+// Sent returns a copy of everything sent so far and clears the log.
+func (h *testHarness) Sent() []sentMessage {
+	h.messenger.mu.Lock()
+	defer h.messenger.mu.Unlock()
+	out := h.messenger.sent
+	h.messenger.sent = nil
+	return out
+}
+
+// ExpectSent fails unless exactly one message was sent and it contains substr.
+func (h *testHarness) ExpectSent(substr string) sentMessage {
+	h.t.Helper()
+	sent := h.Sent()
+	if len(sent) != 1 {
+		h.t.Fatalf("expected 1 outbound message containing %q, got %d: %+v", substr, len(sent), sent)
+	}
+	if !strings.Contains(sent[0].Text, substr) {
+		h.t.Fatalf("expected outbound message containing %q, got %q", substr, sent[0].Text)
+	}
+	return sent[0]
+}
+
+// WaitSent waits for background jobs (like summaries) to send n messages and
+// for any "Summarizing…" placeholder to be edited into its result, then returns them.
+func (h *testHarness) WaitSent(n int) []sentMessage {
+	h.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.messenger.mu.Lock()
+		settled := len(h.messenger.sent) >= n
+		for _, msg := range h.messenger.sent {
+			if strings.HasPrefix(msg.Text, summaryPlaceholder) {
+				settled = false
+			}
+		}
+		h.messenger.mu.Unlock()
+		if settled {
+			return h.Sent()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	h.t.Fatalf("timed out waiting for %d outbound messages, got %+v", n, h.Sent())
+	return nil
+}
+
+// ExpectSentLater is ExpectSent for a reply sent from a background job.
+func (h *testHarness) ExpectSentLater(substr string) sentMessage {
+	h.t.Helper()
+	sent := h.WaitSent(1)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, substr) {
+		h.t.Fatalf("expected 1 outbound message containing %q, got %+v", substr, sent)
+	}
+	return sent[0]
+}
+
+// ExpectNothingSent fails if the bot sent anything.
+func (h *testHarness) ExpectNothingSent() {
+	h.t.Helper()
+	if sent := h.Sent(); len(sent) != 0 {
+		h.t.Fatalf("expected no outbound messages, got %+v", sent)
+	}
+}
+
+// Stored returns what the bot saved for chat, oldest first.
+func (h *testHarness) Stored(chat types.JID) []StoredMessage {
+	h.t.Helper()
+	rows, err := h.bot.DB.ListMessageContext(h.ctx, chat.String(), 0)
+	if err != nil {
+		h.t.Fatalf("failed to list message context: %v", err)
+	}
+	return rows
+}
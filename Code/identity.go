@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// normalizeJID drops the device and agent parts of jid and folds server aliases
+// ("c.us", "hosted", "hosted.lid") into the plain user servers, so the same
+// person compares equal however WhatsApp happened to address them.
+func normalizeJID(jid types.JID) types.JID {
+	server := jid.Server
+	switch server {
+	case types.LegacyUserServer, types.HostedServer:
+		server = types.DefaultUserServer
+	case types.HostedLIDServer:
+		server = types.HiddenUserServer
+	}
+	return types.JID{User: jid.User, Server: server}
+}
+
+// parseNormalizedJID parses a JID string such as a mention and normalizes it.
+// A bare number is taken as a phone number.
+func parseNormalizedJID(s string) (types.JID, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "@")
+	if s == "" {
+		return types.JID{}, false
+	}
+	if !strings.Contains(s, "@") {
+		s += "@" + types.DefaultUserServer
+	}
+	jid, err := types.ParseJID(s)
+	if err != nil || jid.User == "" {
+		return types.JID{}, false
+	}
+	return normalizeJID(jid), true
+}
+
+// Identity knows every JID the bot answers to: its phone number and its LID,
+// on any device.
+type Identity struct {
+	mu   sync.RWMutex
+	jids []types.JID
+}
+
+func NewIdentity(jids ...types.JID) *Identity {
+	id := &Identity{}
+	id.Set(jids...)
+	return id
+}
+
+// Set replaces the known JIDs, ignoring empty ones.
+func (id *Identity) Set(jids ...types.JID) {
+	var normalized []types.JID
+	for _, jid := range jids {
+		if jid.User == "" {
+			continue
+		}
+		jid = normalizeJID(jid)
+		if !containsJID(normalized, jid) {
+			normalized = append(normalized, jid)
+		}
+	}
+
+	id.mu.Lock()
+	id.jids = normalized
+	id.mu.Unlock()
+}
+
+// JIDs returns the known JIDs, device-less.
+func (id *Identity) JIDs() []types.JID {
+	if id == nil {
+		return nil
+	}
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return append([]types.JID(nil), id.jids...)
+}
+
+// IsSelf reports whether jid is one of the bot's, on any device.
+func (id *Identity) IsSelf(jid types.JID) bool {
+	return jid.User != "" && containsJID(id.JIDs(), normalizeJID(jid))
+}
+
+// IsSelfString is IsSelf for JIDs as they appear in mentions and quotes.
+func (id *Identity) IsSelfString(s string) bool {
+	jid, ok := parseNormalizedJID(s)
+	return ok && id.IsSelf(jid)
+}
+
+// Users returns the user parts of the bot's JIDs, as they appear in "@123" mentions.
+func (id *Identity) Users() []string {
+	var users []string
+	for _, jid := range id.JIDs() {
+		users = append(users, jid.User)
+	}
+	return users
+}
+
+func containsJID(jids []types.JID, jid types.JID) bool {
+	for _, known := range jids {
+		if known == jid {
+			return true
+		}
+	}
+	return false
+}
+
+// ? ----------------------------------------------Bot----------------------------------------------
+
+// identity returns the bot's own JIDs, refreshed from the live client's store
+// since the LID is only learned after pairing and the client changes on re-login.
+func (b *Bot) identity() *Identity {
+	if client := b.Client(); client != nil && client.Store != nil {
+		b.Self.Set(client.Store.GetJID(), client.Store.GetLID())
+	}
+	return b.Self
+}
+
+// addressedToBot reports whether the message @mentions the bot, by phone number
+// or LID, or replies to one of its messages.
+func (b *Bot) addressedToBot(ctx *MessageContext) bool {
+	self := b.identity()
+	for _, mention := range ctx.Mentions {
+		if self.IsSelfString(mention) {
+			return true
+		}
+	}
+	return ctx.QuotedSender != "" && self.IsSelfString(ctx.QuotedSender)
+}
+
+// ? ----------------------------------------------LID Resolution----------------------------------------------
+
+// LIDMapper looks up the LID behind a phone number and back; whatsmeow's
+// store.LIDStore implements it.
+type LIDMapper interface {
+	GetLIDForPN(ctx context.Context, pn types.JID) (types.JID, error)
+	GetPNForLID(ctx context.Context, lid types.JID) (types.JID, error)
+}
+
+// lidMapper returns the override set in LIDs, or the live client's LID store.
+func (b *Bot) lidMapper() LIDMapper {
+	if b.LIDs != nil {
+		return b.LIDs
+	}
+	if client := b.Client(); client != nil && client.Store != nil && client.Store.LIDs != nil {
+		return client.Store.LIDs
+	}
+	return nil
+}
+
+// canonicalJID is the one identity used for someone in owner checks, aliases,
+// quotas and stored messages: their LID when WhatsApp has told us the mapping,
+// otherwise their phone-number JID, always without a device.
+func (b *Bot) canonicalJID(jid types.JID) types.JID {
+	jid = normalizeJID(jid)
+	if jid.Server != types.DefaultUserServer || jid.User == "" {
+		return jid
+	}
+
+	b.CanonicalCache.mu.RLock()
+	lid, ok := b.CanonicalCache.lids[jid]
+	b.CanonicalCache.mu.RUnlock()
+	if ok {
+		return lid
+	}
+
+	mapper := b.lidMapper()
+	if mapper == nil {
+		return jid
+	}
+	lid, err := mapper.GetLIDForPN(context.Background(), jid)
+	if err != nil {
+		fmt.Printf("Failed to look up LID for %s: %v\n", jid, err)
+		return jid
+	}
+	// A missing mapping isn't cached, WhatsApp may tell us later.
+	if lid.IsEmpty() {
+		return jid
+	}
+	lid = normalizeJID(lid)
+
+	b.CanonicalCache.mu.Lock()
+	b.CanonicalCache.lids[jid] = lid
+	b.CanonicalCache.mu.Unlock()
+	return lid
+}
+
+// senderJID is the canonical sender of ctx as a string, for database keys.
+func (b *Bot) senderJID(ctx *MessageContext) string {
+	return b.canonicalJID(ctx.SenderID).String()
+}
+
+// canonicalizeStoredJIDs rewrites the phone-number JIDs saved before canonicalJID
+// existed to the LIDs the LID store knows for them. Phone numbers without a
+// known LID are retried on every connect until all of them have moved.
+func (b *Bot) canonicalizeStoredJIDs(ctx context.Context) error {
+	mapper := b.lidMapper()
+	if mapper == nil {
+		return nil
+	}
+	pns, err := b.DB.PhoneNumberJIDs(ctx)
+	if err != nil || len(pns) == 0 {
+		return err
+	}
+
+	lids := make(map[string]string)
+	for _, pn := range pns {
+		jid, err := types.ParseJID(pn)
+		if err != nil {
+			continue
+		}
+		lid, err := mapper.GetLIDForPN(ctx, jid)
+		if err != nil {
+			return err
+		}
+		if !lid.IsEmpty() {
+			lids[pn] = normalizeJID(lid).String()
+		}
+	}
+	if len(lids) == 0 {
+		return nil
+	}
+	if err := b.DB.RewritePhoneNumberJIDs(ctx, lids); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d of %d stored phone numbers to their LIDs\n", len(lids), len(pns))
+	return nil
+}
+
+// ? ----------------------------------------------Names----------------------------------------------
+
+// ContactLookup finds the names WhatsApp knows for a user; whatsmeow's
+// store.ContactStore implements it.
+type ContactLookup interface {
+	GetContact(ctx context.Context, user types.JID) (types.ContactInfo, error)
+}
+
+// contactStore returns the override set in Contacts, or the live client's contact store.
+func (b *Bot) contactStore() ContactLookup {
+	if b.Contacts != nil {
+		return b.Contacts
+	}
+	if client := b.Client(); client != nil && client.Store != nil && client.Store.Contacts != nil {
+		return client.Store.Contacts
+	}
+	return nil
+}
+
+// senderName is how a sender appears in stored messages and prompts. It never
+// returns "", so a message is never dropped for want of a name.
+func (b *Bot) senderName(ctx *MessageContext) string {
+	if alias := b.senderAlias(ctx); alias != "" {
+		return alias
+	}
+	return b.displayName(ctx.SenderID, ctx.SenderName)
+}
+
+// displayName names someone without an alias: the name saved in the bot's
+// contacts, then their push name, then their phone number.
+func (b *Bot) displayName(jid types.JID, pushName string) string {
+	if contacts := b.contactStore(); contacts != nil {
+		for _, candidate := range b.jidVariants(jid) {
+			info, err := contacts.GetContact(context.Background(), candidate)
+			if err != nil || !info.Found {
+				continue
+			}
+			for _, name := range []string{info.FullName, info.FirstName, info.BusinessName} {
+				if name = strings.TrimSpace(name); name != "" {
+					return name
+				}
+			}
+			if pushName == "" {
+				pushName = info.PushName
+			}
+		}
+	}
+	if pushName = strings.TrimSpace(pushName); pushName != "" {
+		return pushName
+	}
+	return b.formatPhone(jid)
+}
+
+// jidVariants returns jid without its device plus the other half of its
+// LID/phone-number pair when it's known.
+func (b *Bot) jidVariants(jid types.JID) []types.JID {
+	jid = normalizeJID(jid)
+	variants := []types.JID{jid}
+	if other := b.otherJID(jid); !other.IsEmpty() && other != jid {
+		variants = append(variants, other)
+	}
+	return variants
+}
+
+// otherJID returns the phone number for a LID or the LID for a phone number, or an empty JID.
+func (b *Bot) otherJID(jid types.JID) types.JID {
+	switch jid.Server {
+	case types.DefaultUserServer:
+		if lid := b.canonicalJID(jid); lid != jid {
+			return lid
+		}
+	case types.HiddenUserServer:
+		if mapper := b.lidMapper(); mapper != nil {
+			if pn, err := mapper.GetPNForLID(context.Background(), jid); err == nil && !pn.IsEmpty() {
+				return normalizeJID(pn)
+			}
+		}
+	}
+	return types.EmptyJID
+}
+
+// formatPhone shows jid as "+5215512345678" when its phone number is known, or its bare user part.
+func (b *Bot) formatPhone(jid types.JID) string {
+	jid = normalizeJID(jid)
+	if jid.Server == types.HiddenUserServer {
+		if pn := b.otherJID(jid); !pn.IsEmpty() {
+			jid = pn
+		}
+	}
+	if jid.Server == types.DefaultUserServer {
+		return "+" + jid.User
+	}
+	return jid.User
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ModelLimits describes how much a model can read and write and what it costs.
+// Any zero field in Config.Models falls back to the built-in value.
+type ModelLimits struct {
+	ContextTokens   int     `json:"ContextTokens"`
+	MaxOutputTokens int     `json:"MaxOutputTokens"`
+	ChunkTokens     int     `json:"ChunkTokens"`   // transcript tokens per chunk when a chat has to be split
+	InputPerMTok    float64 `json:"InputPerMTok"`  // USD per million prompt tokens
+	OutputPerMTok   float64 `json:"OutputPerMTok"` // USD per million completion tokens
+}
+
+var defaultModelLimits = map[string]ModelLimits{
+	deepSeekChatModel:   {ContextTokens: 128000, MaxOutputTokens: 8000, ChunkTokens: 24000, InputPerMTok: 0.28, OutputPerMTok: 0.42},
+	deepSeekReasonModel: {ContextTokens: 128000, MaxOutputTokens: 32000, ChunkTokens: 24000, InputPerMTok: 0.28, OutputPerMTok: 0.42},
+}
+
+const (
+	defaultMaxCostPerSummary = 0.05 // USD
+	chunkSummaryTokens       = 600  // output cap for each partial summary
+	maxSummaryLevels         = 3    // summaries of summaries of summaries, then give up
+)
+
+var errCostLimit = errors.New("summary cost limit reached")
+
+// ModelLimits returns the limits for model with any configured overrides applied.
+func (c *Config) ModelLimits(model string) ModelLimits {
+	limits := defaultModelLimits[model]
+	override, ok := c.Models[model]
+	if !ok {
+		return limits
+	}
+	if override.ContextTokens > 0 {
+		limits.ContextTokens = override.ContextTokens
+	}
+	if override.MaxOutputTokens > 0 {
+		limits.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.ChunkTokens > 0 {
+		limits.ChunkTokens = override.ChunkTokens
+	}
+	if override.InputPerMTok > 0 {
+		limits.InputPerMTok = override.InputPerMTok
+	}
+	if override.OutputPerMTok > 0 {
+		limits.OutputPerMTok = override.OutputPerMTok
+	}
+	return limits
+}
+
+// MaxSummaryCost returns the per-request cost cap in USD.
+func (c *Config) MaxSummaryCost() float64 {
+	if c.MaxCostPerSummary > 0 {
+		return c.MaxCostPerSummary
+	}
+	return defaultMaxCostPerSummary
+}
+
+// Cost returns the USD price of a call with the given token counts.
+func (l ModelLimits) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*l.InputPerMTok + float64(completionTokens)*l.OutputPerMTok) / 1e6
+}
+
+// transcriptBudget is how many transcript tokens fit in one call next to overhead
+// prompt tokens while leaving room for the answer.
+func (l ModelLimits) transcriptBudget(overhead int) int {
+	budget := l.ContextTokens - l.MaxOutputTokens - overhead
+	if l.ChunkTokens > 0 {
+		budget = min(budget, l.ChunkTokens)
+	}
+	return max(budget, 1)
+}
+
+// estimateTokens guesses a token count without a tokenizer. DeepSeek averages
+// three to four characters per token on chat text; this leans to the high side.
+func estimateTokens(s string) int {
+	return utf8.RuneCountInString(s)/3 + 1
+}
+
+func estimateMessagesTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg.Content) + 4 // role and separators
+	}
+	return total
+}
+
+// ? ----------------------------------------------Transcript----------------------------------------------
+
+// transcriptLines renders stored rows as compact "15:04 Alias: text" lines, with a
+// date line whenever the day changes and media descriptions in brackets.
+func transcriptLines(messages []StoredMessage) []string {
+	lines := make([]string, 0, len(messages))
+	var day string
+	for _, msg := range messages {
+		if d := msg.Timestamp.Format("2006-01-02"); d != day {
+			day = d
+			lines = append(lines, "-- "+d+" --")
+		}
+
+		var sb strings.Builder
+		sb.WriteString(msg.Timestamp.Format("15:04") + " " + msg.SenderName + ":")
+		if msg.MediaDescription != "" {
+			sb.WriteString(" [" + strings.Join(strings.Fields(msg.MediaDescription), " ") + "]")
+		}
+		if text := strings.Join(strings.Fields(msg.Text), " "); text != "" {
+			sb.WriteString(" " + text)
+		}
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// chunkLines groups lines into chunks of at most budget tokens. A single line over
+// the budget is cut down to fit rather than dropped.
+func chunkLines(lines []string, budget int) [][]string {
+	var chunks [][]string
+	var current []string
+	size := 0
+	for _, line := range lines {
+		tokens := estimateTokens(line)
+		if tokens > budget {
+			line = truncateRunes(line, max((budget-2)*3, 1))
+			tokens = budget
+		}
+		if size+tokens > budget && len(current) > 0 {
+			chunks = append(chunks, current)
+			current, size = nil, 0
+		}
+		current = append(current, line)
+		size += tokens
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+func estimateLinesTokens(lines []string) int {
+	total := 0
+	for _, line := range lines {
+		total += estimateTokens(line)
+	}
+	return total
+}
+
+// ? ----------------------------------------------Summarizer----------------------------------------------
+
+// Summarizer turns a transcript of any length into one summary. When the transcript
+// doesn't fit the model's budget it summarizes chunks first, then summarizes those
+// summaries, stopping before it would spend more than MaxCost.
+//
+// Partial summaries run on ChunkModel: they're plain condensing, and a reasoning
+// model would spend their small output allowance on thinking.
+type Summarizer struct {
+	LLM         LLMClient
+	Prompts     *PromptsConfig
+	Model       string
+	Limits      ModelLimits
+	ChunkModel  string
+	ChunkLimits ModelLimits
+	MaxCost     float64
+
+	// Passed along with every call for usage accounting.
+	ChatJID      string
+	RequesterJID string
+
+	// OnChunk, if set, is called after each partial summary.
+	OnChunk func(done, total int)
+
+	Calls  int
+	Tokens int
+	Spent  float64
+}
+
+// SummaryResult is the final text plus what it took to get there.
+type SummaryResult struct {
+	Content string
+	Calls   int
+	Levels  int
+	Tokens  int
+	Cost    float64
+}
+
+// overhead estimates the prompt tokens a call spends on everything but the transcript.
+func (s *Summarizer) overhead(info *SummaryInfo) int {
+	return estimateMessagesTokens(buildSummaryPrompt(s.Prompts, info, "", true))
+}
+
+// budget is how many transcript tokens one call may carry, the smaller of what
+// the chunk and final models allow.
+func (s *Summarizer) budget(info *SummaryInfo) int {
+	overhead := s.overhead(info)
+	return min(s.Limits.transcriptBudget(overhead), s.ChunkLimits.transcriptBudget(overhead))
+}
+
+// EstimateCost predicts the cost of summarizing lines, assuming every call uses
+// its whole output allowance.
+func (s *Summarizer) EstimateCost(info *SummaryInfo, lines []string) float64 {
+	overhead := s.overhead(info)
+	budget := s.budget(info)
+	tokens := estimateLinesTokens(lines)
+
+	cost := 0.0
+	for level := 0; tokens > budget && level < maxSummaryLevels; level++ {
+		chunks := (tokens + budget - 1) / budget
+		cost += s.ChunkLimits.Cost(tokens+chunks*overhead, chunks*chunkSummaryTokens)
+		tokens = chunks * chunkSummaryTokens
+	}
+	return cost + s.Limits.Cost(tokens+overhead, s.Limits.MaxOutputTokens)
+}
+
+// Summarize runs the chunked summarization and returns the final summary.
+func (s *Summarizer) Summarize(ctx context.Context, info *SummaryInfo, lines []string) (*SummaryResult, error) {
+	budget := s.budget(info)
+
+	partial := false
+	levels := 0
+	for estimateLinesTokens(lines) > budget {
+		if levels == maxSummaryLevels {
+			return nil, fmt.Errorf("transcript still too long after %d rounds of summaries", levels)
+		}
+		chunks := chunkLines(lines, budget)
+		next := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			prompt := buildChunkPrompt(s.Prompts, i+1, len(chunks), strings.Join(chunk, "\n"))
+			content, err := s.complete(ctx, s.ChunkModel, s.ChunkLimits, prompt, chunkSummaryTokens)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, fmt.Sprintf("Part %d of %d:\n%s", i+1, len(chunks), strings.TrimSpace(content)))
+			if s.OnChunk != nil {
+				s.OnChunk(i+1, len(chunks))
+			}
+		}
+		lines = next
+		partial = true
+		levels++
+	}
+
+	prompt := buildSummaryPrompt(s.Prompts, info, strings.Join(lines, "\n"), partial)
+	content, err := s.complete(ctx, s.Model, s.Limits, prompt, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &SummaryResult{Content: content, Calls: s.Calls, Levels: levels, Tokens: s.Tokens, Cost: s.Spent}, nil
+}
+
+// complete makes one model call, refusing it if its worst case would exceed MaxCost.
+func (s *Summarizer) complete(ctx context.Context, model string, limits ModelLimits, messages []ChatMessage, maxTokens int) (string, error) {
+	worstOutput := maxTokens
+	if worstOutput <= 0 {
+		worstOutput = limits.MaxOutputTokens
+	}
+	if s.MaxCost > 0 && s.Spent+limits.Cost(estimateMessagesTokens(messages), worstOutput) > s.MaxCost {
+		return "", errCostLimit
+	}
+
+	start := time.Now()
+	resp, err := s.LLM.Complete(ctx, ChatRequest{
+		Model:        model,
+		Messages:     messages,
+		MaxTokens:    maxTokens,
+		ChatJID:      s.ChatJID,
+		RequesterJID: s.RequesterJID,
+	})
+	if err != nil {
+		return "", err
+	}
+	s.Calls++
+	s.Tokens += resp.PromptTokens + resp.CompletionTokens
+	s.Spent += limits.Cost(resp.PromptTokens, resp.CompletionTokens)
+	fmt.Printf("LLM call %d: %d prompt + %d completion tokens in %s\n", s.Calls, resp.PromptTokens, resp.CompletionTokens, time.Since(start).Round(time.Millisecond))
+	return resp.Content, nil
+}
+
+// buildChunkPrompt asks for a partial summary of one piece of a long conversation.
+func buildChunkPrompt(prompts *PromptsConfig, part, total int, transcript string) []ChatMessage {
+	instructions := prompts.ChunkSummaryPrompt
+	if instructions == "" {
+		instructions = "This is one part of a longer conversation. Summarize it so it can be merged with the other parts later: keep names, times, decisions, open questions and anything someone was asked to do."
+	}
+	return []ChatMessage{
+		{Role: "system", Content: prompts.PersonalityPrompt},
+		{Role: "user", Content: fmt.Sprintf("%s\n\nPart %d of %d:\n%s", instructions, part, total, transcript)},
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// conversationContextMessages and conversationContextTokens bound how much
+	// recent chat goes along with a question, whichever is smaller.
+	conversationContextMessages = 80
+	conversationContextTokens   = 6000
+	conversationMaxTokens       = 800
+)
+
+// stripMentions removes "@user" mention tokens for the given users and tidies the spacing.
+func stripMentions(text string, users []string) string {
+	for _, user := range users {
+		if user != "" {
+			text = strings.ReplaceAll(text, "@"+user, "")
+		}
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// lastLinesWithin keeps the most recent lines that fit in budget tokens.
+func lastLinesWithin(lines []string, budget int) []string {
+	size := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		size += estimateTokens(lines[i])
+		if size > budget {
+			return lines[i+1:]
+		}
+	}
+	return lines
+}
+
+// buildConversationPrompt asks the model to answer asker's question using the
+// recent chat. quoted is the message being replied to, if any, and quotedAuthor
+// names who sent it, or is "" when it's the bot's own.
+func buildConversationPrompt(prompts *PromptsConfig, transcript, asker, quoted, quotedAuthor, question string) []ChatMessage {
+	instructions := prompts.ConversationPrompt
+	if instructions == "" {
+		instructions = "You are a member of this WhatsApp group and someone is talking to you. Answer them briefly and in their language. Use the recent messages when the question is about the chat; if the answer isn't there, say so instead of guessing."
+	}
+
+	parts := []string{instructions}
+	if transcript != "" {
+		parts = append(parts, "Recent messages:\n"+transcript)
+	}
+	switch {
+	case quoted != "" && quotedAuthor == "":
+		parts = append(parts, "They are replying to your message:\n"+quoted)
+	case quoted != "":
+		parts = append(parts, fmt.Sprintf("They are replying to this message from %s:\n%s", quotedAuthor, quoted))
+	}
+	parts = append(parts, fmt.Sprintf("%s says to you: %s", asker, question))
+
+	return []ChatMessage{
+		{Role: "system", Content: prompts.PersonalityPrompt},
+		{Role: "user", Content: strings.Join(parts, "\n\n")},
+	}
+}
+
+// mentionNames replaces "@123" mentions of other people with their alias, so the
+// model can match them to names in the transcript.
+func (b *Bot) mentionNames(ctx *MessageContext, text string) string {
+	for _, mention := range ctx.Mentions {
+		jid, ok := parseNormalizedJID(mention)
+		if !ok {
+			continue
+		}
+		alias, err := isAliasCached(b.AliasCache, ctx.ChatID.String(), b.canonicalJID(jid).String(), b.DB)
+		if err == nil && alias != "" {
+			text = strings.ReplaceAll(text, "@"+jid.User, "@"+alias)
+		}
+	}
+	return text
+}
+
+// quotedAuthor names the sender of the message ctx replies to, or returns "" when
+// that's the bot itself.
+func (b *Bot) quotedAuthor(ctx *MessageContext) string {
+	if b.identity().IsSelfString(ctx.QuotedSender) {
+		return ""
+	}
+	jid, ok := parseNormalizedJID(ctx.QuotedSender)
+	if !ok {
+		return "someone else"
+	}
+	alias, err := isAliasCached(b.AliasCache, ctx.ChatID.String(), b.canonicalJID(jid).String(), b.DB)
+	if err == nil && alias != "" {
+		return alias
+	}
+	return b.displayName(jid, "")
+}
+
+// handleConversation answers a message addressed to the bot with an LLM reply,
+// using the chat's recent messages as context. It runs as a background job and
+// shares the summary quotas.
+func (b *Bot) handleConversation(ctx *MessageContext, question string) {
+	b.Shutdown.Go(func(jobCtx context.Context) {
+		work := b.startWork(ctx)
+		succeeded := false
+		defer func() { work.Done(succeeded) }()
+
+		messages, err := b.DB.ListMessageContext(jobCtx, ctx.ChatID.String(), conversationContextMessages)
+		if err != nil {
+			fmt.Printf("Failed to load conversation context: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to load messages.")
+			return
+		}
+		// The question itself may already be stored; it's added separately.
+		for i, msg := range messages {
+			if msg.MessageID == ctx.MessageID {
+				messages = append(messages[:i], messages[i+1:]...)
+				break
+			}
+		}
+		transcript := strings.Join(lastLinesWithin(transcriptLines(messages), conversationContextTokens), "\n")
+
+		prompt := buildConversationPrompt(b.Prompts(), transcript, b.senderName(ctx), ctx.QuotedText, b.quotedAuthor(ctx), b.mentionNames(ctx, question))
+		limits := b.Config().ModelLimits(deepSeekChatModel)
+
+		quota := QuotaRequest{User: b.canonicalJID(ctx.SenderID), Chat: ctx.ChatID, EstimatedCost: limits.Cost(estimateMessagesTokens(prompt), conversationMaxTokens)}
+		denial, err := b.checkQuota(jobCtx, quota, time.Now())
+		if err != nil {
+			fmt.Printf("Failed to check quota: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "I can't answer right now, try again later.")
+			return
+		}
+		if denial != "" {
+			b.Messenger.SendReplyMessage(ctx, denial)
+			return
+		}
+
+		start := time.Now()
+		resp, err := b.llm().Complete(jobCtx, ChatRequest{
+			Model:        deepSeekChatModel,
+			Messages:     prompt,
+			MaxTokens:    conversationMaxTokens,
+			ChatJID:      ctx.ChatID.String(),
+			RequesterJID: b.senderJID(ctx),
+		})
+		if err != nil {
+			fmt.Printf("Conversation reply failed: %v\n", err)
+			if err := b.refundQuota(jobCtx, quota); err != nil {
+				fmt.Printf("Failed to refund quota: %v\n", err)
+			}
+			b.Messenger.SendReplyMessage(ctx, "I can't answer right now, try again later.")
+			return
+		}
+		cost := limits.Cost(resp.PromptTokens, resp.CompletionTokens)
+		if err := b.recordQuota(jobCtx, quota, resp.PromptTokens+resp.CompletionTokens, cost, time.Now()); err != nil {
+			fmt.Printf("Failed to record quota usage: %v\n", err)
+		}
+		fmt.Printf("Answered a mention with %d messages of context in %s: $%.4f\n", len(messages), time.Since(start).Round(time.Millisecond), cost)
+
+		if err := b.sendFormattedReply(ctx, "", resp.Content); err != nil {
+			fmt.Printf("Failed to send conversation reply: %v\n", err)
+			return
+		}
+		succeeded = true
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	deepSeekBaseURL     = "https://api.deepseek.com"
+	deepSeekChatModel   = "deepseek-chat"
+	deepSeekReasonModel = "deepseek-reasoner"
+)
+
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ChatRequest struct {
+	Model     string
+	Messages  []ChatMessage
+	MaxTokens int
+
+	// Who the call is for; only used for usage accounting, never sent.
+	ChatJID      string
+	RequesterJID string
+}
+
+type ChatResponse struct {
+	Content          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// LLMClient is what the summarizer talks to; tests swap in a fake.
+type LLMClient interface {
+	Complete(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+}
+
+// DeepSeekClient calls DeepSeek's OpenAI-compatible chat completions endpoint.
+type DeepSeekClient struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewDeepSeekClient(token string) *DeepSeekClient {
+	return &DeepSeekClient{
+		BaseURL: deepSeekBaseURL,
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 5 * time.Minute}, // the reasoner can think for a while
+	}
+}
+
+type deepSeekRequest struct {
+	Model     string        `json:"model"`
+	Messages  []ChatMessage `json:"messages"`
+	MaxTokens int           `json:"max_tokens,omitempty"`
+	Stream    bool          `json:"stream"`
+}
+
+type deepSeekResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message ChatMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *DeepSeekClient) Complete(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	if strings.TrimSpace(c.Token) == "" {
+		return nil, errors.New("API token is not configured")
+	}
+
+	body, err := json.Marshal(deepSeekRequest{
+		Model:     req.Model,
+		Messages:  req.Messages,
+		MaxTokens: req.MaxTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.BaseURL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+
+	httpResp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	raw, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed deepSeekResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("unexpected response (HTTP %d): %s", httpResp.StatusCode, truncate(string(raw), 200))
+	}
+	if parsed.Error != nil {
+		return nil, fmt.Errorf("API error (HTTP %d): %s", httpResp.StatusCode, parsed.Error.Message)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned HTTP %d", httpResp.StatusCode)
+	}
+	if len(parsed.Choices) == 0 {
+		return nil, errors.New("API returned no choices")
+	}
+
+	return &ChatResponse{
+		Content:          parsed.Choices[0].Message.Content,
+		Model:            parsed.Model,
+		PromptTokens:     parsed.Usage.PromptTokens,
+		CompletionTokens: parsed.Usage.CompletionTokens,
+	}, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
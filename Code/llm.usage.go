@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// usageRecorder forwards to the bot's LLM and records every call, successful
+// or not, in app_llm_usage. Handlers should call the model through Bot.llm.
+type usageRecorder struct {
+	bot *Bot
+}
+
+func (b *Bot) llm() LLMClient {
+	return usageRecorder{bot: b}
+}
+
+func (r usageRecorder) Complete(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	start := time.Now()
+	resp, err := r.bot.LLM.Complete(ctx, req)
+
+	usage := LLMUsage{
+		ChatJID:      req.ChatJID,
+		RequesterJID: req.RequesterJID,
+		Model:        req.Model,
+		Latency:      time.Since(start),
+		Success:      err == nil,
+		CreatedAt:    time.Now(),
+	}
+	if err != nil {
+		usage.Error = err.Error()
+	}
+	if resp != nil {
+		usage.PromptTokens = resp.PromptTokens
+		usage.CompletionTokens = resp.CompletionTokens
+		usage.Cost = r.bot.Config().ModelLimits(req.Model).Cost(resp.PromptTokens, resp.CompletionTokens)
+	}
+
+	// Record even when the request was cancelled by shutdown.
+	if err := r.bot.DB.InsertLLMUsage(context.WithoutCancel(ctx), usage); err != nil {
+		fmt.Printf("Failed to record LLM usage: %v\n", err)
+	}
+	return resp, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// defaultMaxReplyLength keeps each part of a long reply readable on a phone.
+// WhatsApp itself allows far more.
+const defaultMaxReplyLength = 3000
+
+const (
+	// boldMark stands in for WhatsApp's "*" while markdown italics are still being converted.
+	boldMark = "\x01"
+	// codePlaceholderFmt marks where a code span was set aside.
+	codePlaceholderFmt = "\x02%d\x02"
+)
+
+var (
+	fencedCodePattern = regexp.MustCompile("(?s)```[a-zA-Z0-9_+-]*\\n?(.*?)```")
+	inlineCodePattern = regexp.MustCompile("`([^`\\n]+)`")
+	headingPattern    = regexp.MustCompile(`(?m)^#{1,6}\s+(.+?)\s*#*\s*$`)
+	bulletPattern     = regexp.MustCompile(`(?m)^(\s*)[*+]\s+`)
+	boldStarPattern   = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	boldUnderPattern  = regexp.MustCompile(`__(.+?)__`)
+	italicStarPattern = regexp.MustCompile(`(^|[^\w*])\*([^*\s](?:[^*\n]*[^*\s])?)\*`)
+	strikePattern     = regexp.MustCompile(`~~(.+?)~~`)
+	linkPattern       = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	ruleLinePattern   = regexp.MustCompile(`(?m)^\s*(?:-{3,}|\*{3,}|_{3,})\s*$`)
+)
+
+// markdownToWhatsApp rewrites the markdown models like to produce into WhatsApp's
+// own formatting: **bold** → *bold*, *italic* → _italic_, ~~strike~~ → ~strike~,
+// headings → bold lines, fenced code → ```monospace``` and [text](url) → text (url).
+// Inline `code` is left as is, WhatsApp shows it the same way.
+func markdownToWhatsApp(s string) string {
+	// Code is copied through untouched, so set it aside first.
+	var code []string
+	keep := func(block string) string {
+		code = append(code, block)
+		return fmt.Sprintf(codePlaceholderFmt, len(code)-1)
+	}
+	s = fencedCodePattern.ReplaceAllStringFunc(s, func(m string) string {
+		return keep("```" + strings.TrimRight(fencedCodePattern.FindStringSubmatch(m)[1], "\n") + "```")
+	})
+	s = inlineCodePattern.ReplaceAllStringFunc(s, keep)
+
+	s = ruleLinePattern.ReplaceAllString(s, "")
+	s = headingPattern.ReplaceAllString(s, boldMark+"$1"+boldMark)
+	s = bulletPattern.ReplaceAllString(s, "$1- ")
+	s = boldStarPattern.ReplaceAllString(s, boldMark+"$1"+boldMark)
+	s = boldUnderPattern.ReplaceAllString(s, boldMark+"$1"+boldMark)
+	s = italicStarPattern.ReplaceAllString(s, "${1}_${2}_")
+	s = strikePattern.ReplaceAllString(s, "~$1~")
+	s = linkPattern.ReplaceAllString(s, "$1 ($2)")
+	s = strings.ReplaceAll(s, boldMark+boldMark, "")
+	s = strings.ReplaceAll(s, boldMark, "*")
+
+	for i, block := range code {
+		s = strings.Replace(s, fmt.Sprintf(codePlaceholderFmt, i), block, 1)
+	}
+	return strings.TrimSpace(s)
+}
+
+// splitReply breaks text into parts of at most limit characters, preferring
+// paragraph breaks, then line breaks, then spaces. When there is more than one
+// part each gets a "(1/3)" marker, which is counted against the limit.
+func splitReply(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	// Room for a marker like "(12/12)\n".
+	const markerRoom = 8
+	room := max(limit-markerRoom, 1)
+
+	var parts []string
+	var current strings.Builder
+	flush := func() {
+		if part := strings.TrimSpace(current.String()); part != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+	}
+	add := func(piece, sep string) {
+		if current.Len() > 0 && utf8.RuneCountInString(current.String())+len(sep)+utf8.RuneCountInString(piece) > room {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(piece)
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if utf8.RuneCountInString(paragraph) <= room {
+			add(paragraph, "\n\n")
+			continue
+		}
+		sep := "\n\n"
+		for _, line := range strings.Split(paragraph, "\n") {
+			if utf8.RuneCountInString(line) <= room {
+				add(line, sep)
+				sep = "\n"
+				continue
+			}
+			for _, chunk := range splitWords(line, room) {
+				add(chunk, sep)
+				sep = " "
+			}
+			sep = "\n"
+		}
+	}
+	flush()
+
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+		}
+	}
+	return parts
+}
+
+// splitWords cuts a single overlong line on spaces, hard-cutting words longer than room.
+func splitWords(line string, room int) []string {
+	var out []string
+	var current []rune
+	for _, word := range strings.Fields(line) {
+		runes := []rune(word)
+		for len(runes) > room {
+			if len(current) > 0 {
+				out = append(out, string(current))
+				current = nil
+			}
+			out = append(out, string(runes[:room]))
+			runes = runes[room:]
+		}
+		if len(current) > 0 && len(current)+1+len(runes) > room {
+			out = append(out, string(current))
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, runes...)
+	}
+	if len(current) > 0 {
+		out = append(out, string(current))
+	}
+	return out
+}
+
+// ? ----------------------------------------------Long Replies----------------------------------------------
+
+// summaryPlaceholder is shown while a summary is being written, then edited into the result.
+const summaryPlaceholder = "Summarizing…"
+
+// ReplyLimit returns the longest single reply before it's split into parts.
+func (c *Config) ReplyLimit() int {
+	if c.MaxReplyLength > 0 {
+		return c.MaxReplyLength
+	}
+	return defaultMaxReplyLength
+}
+
+// sendFormattedReply converts model markdown for WhatsApp and replies with it,
+// split into numbered parts if it's long. If placeholderID is set the first part
+// replaces that message; the rest follow as new messages. It stops at the first
+// part that fails to send, so the chat never gets a reply with a gap in it.
+func (b *Bot) sendFormattedReply(ctx *MessageContext, placeholderID types.MessageID, text string) error {
+	parts := splitReply(markdownToWhatsApp(text), b.Config().ReplyLimit())
+
+	for i, part := range parts {
+		var err error
+		switch {
+		case i == 0 && placeholderID != "":
+			if err = b.Messenger.EditMessage(ctx.ChatID, placeholderID, part); err != nil {
+				fmt.Printf("Failed to edit placeholder, replying instead: %v\n", err)
+				err = b.Messenger.SendReplyMessage(ctx, part)
+			}
+		case i == 0:
+			err = b.Messenger.SendReplyMessage(ctx, part)
+		default:
+			err = b.Messenger.SendTextMessage(ctx.ChatID, part)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send reply part %d/%d: %w", i+1, len(parts), err)
+		}
+	}
+	return nil
+}
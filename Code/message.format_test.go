@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMarkdownToWhatsApp(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"**Decisions**", "*Decisions*"},
+		{"## Action items", "*Action items*"},
+		{"this is *important* and __this__ too", "this is _important_ and *this* too"},
+		{"~~cancelled~~ meeting", "~cancelled~ meeting"},
+		{"* first\n* second", "- first\n- second"},
+		{"run `go test` now", "run `go test` now"},
+		{"`**not bold**` but **bold**", "`**not bold**` but *bold*"},
+		{"```go\nfmt.Println(\"**x**\")\n```", "```fmt.Println(\"**x**\")```"},
+		{"see [the doc](https://example.com/a)", "see the doc (https://example.com/a)"},
+		{"2 * 3 * 4", "2 * 3 * 4"},
+	}
+	for _, tt := range tests {
+		if got := markdownToWhatsApp(tt.in); got != tt.want {
+			t.Errorf("markdownToWhatsApp(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitReply(t *testing.T) {
+	if parts := splitReply("short", 100); len(parts) != 1 || parts[0] != "short" {
+		t.Fatalf("short text should not be split: %q", parts)
+	}
+
+	paragraph := strings.Repeat("word ", 15) // 75 chars
+	text := strings.Join([]string{paragraph, paragraph, paragraph, strings.Repeat("x", 250)}, "\n\n")
+	parts := splitReply(text, 100)
+	if len(parts) < 4 {
+		t.Fatalf("expected at least 4 parts, got %d: %q", len(parts), parts)
+	}
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > 100 {
+			t.Errorf("part %d is %d chars, over the limit", i+1, n)
+		}
+		if !strings.HasPrefix(part, "(") || !strings.Contains(part, "/") {
+			t.Errorf("part %d is missing its marker: %q", i+1, part)
+		}
+	}
+	if !strings.HasPrefix(parts[0], "(1/") || !strings.HasSuffix(parts[0], "word") {
+		t.Errorf("first part should be the first paragraph: %q", parts[0])
+	}
+}
@@ -9,6 +9,93 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// Messenger is the outbound side of WhatsApp used by the handlers.
+type Messenger interface {
+	SendTextMessage(chatJID types.JID, message string) error
+	SendReplyMessage(messageContext *MessageContext, message string) error
+	// SendReplyMessageID is SendReplyMessage returning the new message's ID, for EditMessage.
+	SendReplyMessageID(messageContext *MessageContext, message string) (types.MessageID, error)
+	EditMessage(chatJID types.JID, messageID types.MessageID, message string) error
+
+	// SetTyping shows or clears the "typing…" indicator in a chat.
+	SetTyping(chatJID types.JID, typing bool) error
+	// SetOnline shows the account as online or clears it; typing only shows while online.
+	SetOnline(online bool) error
+	MarkRead(messageContext *MessageContext) error
+	// React puts emoji on a message; an empty emoji removes the reaction.
+	React(messageContext *MessageContext, emoji string) error
+
+	// Upload encrypts and uploads media so it can be sent with SendSticker.
+	Upload(data []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error)
+	SendSticker(chatJID types.JID, sticker *whatsmeow.UploadResponse, width, height int) error
+	SendAudio(chatJID types.JID, audio *whatsmeow.UploadResponse, mimetype string) error
+
+	LeaveGroup(chatJID types.JID) error
+	JoinedGroups() ([]*types.GroupInfo, error)
+}
+
+// ClientMessenger sends through whichever whatsmeow client is current,
+// since the supervisor swaps the client after a re-login.
+type ClientMessenger struct {
+	client func() *whatsmeow.Client
+}
+
+func NewClientMessenger(client func() *whatsmeow.Client) *ClientMessenger {
+	return &ClientMessenger{client: client}
+}
+
+func (m *ClientMessenger) SendTextMessage(chatJID types.JID, message string) error {
+	return SendTextMessage(m.client(), chatJID, message)
+}
+
+func (m *ClientMessenger) SendReplyMessage(messageContext *MessageContext, message string) error {
+	return SendReplyMessage(m.client(), messageContext, message)
+}
+
+func (m *ClientMessenger) SendReplyMessageID(messageContext *MessageContext, message string) (types.MessageID, error) {
+	return SendReplyMessageID(m.client(), messageContext, message)
+}
+
+func (m *ClientMessenger) EditMessage(chatJID types.JID, messageID types.MessageID, message string) error {
+	return EditMessage(m.client(), chatJID, messageID, message)
+}
+
+func (m *ClientMessenger) SetTyping(chatJID types.JID, typing bool) error {
+	return SetTyping(m.client(), chatJID, typing)
+}
+
+func (m *ClientMessenger) SetOnline(online bool) error {
+	return SetOnline(m.client(), online)
+}
+
+func (m *ClientMessenger) MarkRead(messageContext *MessageContext) error {
+	return MarkRead(m.client(), messageContext)
+}
+
+func (m *ClientMessenger) React(messageContext *MessageContext, emoji string) error {
+	return React(m.client(), messageContext, emoji)
+}
+
+func (m *ClientMessenger) Upload(data []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error) {
+	return UploadMedia(m.client(), data, mediaType)
+}
+
+func (m *ClientMessenger) SendSticker(chatJID types.JID, sticker *whatsmeow.UploadResponse, width, height int) error {
+	return SendSticker(m.client(), chatJID, sticker, width, height)
+}
+
+func (m *ClientMessenger) SendAudio(chatJID types.JID, audio *whatsmeow.UploadResponse, mimetype string) error {
+	return SendAudio(m.client(), chatJID, audio, mimetype)
+}
+
+func (m *ClientMessenger) LeaveGroup(chatJID types.JID) error {
+	return m.client().LeaveGroup(context.Background(), chatJID)
+}
+
+func (m *ClientMessenger) JoinedGroups() ([]*types.GroupInfo, error) {
+	return m.client().GetJoinedGroups(context.Background())
+}
+
 func SendTextMessage(client *whatsmeow.Client, chatJID types.JID, message string) error {
 	_, err := client.SendMessage(context.Background(), chatJID, &waProto.Message{
 		Conversation: &message,
@@ -17,6 +104,11 @@ func SendTextMessage(client *whatsmeow.Client, chatJID types.JID, message string
 }
 
 func SendReplyMessage(client *whatsmeow.Client, messageContext *MessageContext, message string) error {
+	_, err := SendReplyMessageID(client, messageContext, message)
+	return err
+}
+
+func SendReplyMessageID(client *whatsmeow.Client, messageContext *MessageContext, message string) (types.MessageID, error) {
 	contextInfo := &waProto.ContextInfo{
 		StanzaID:      &messageContext.MessageID,
 		Participant:   proto.String(messageContext.SenderID.String()),
@@ -30,6 +122,100 @@ func SendReplyMessage(client *whatsmeow.Client, messageContext *MessageContext,
 		},
 	}
 
-	_, err := client.SendMessage(context.Background(), messageContext.ChatID, msg)
+	resp, err := client.SendMessage(context.Background(), messageContext.ChatID, msg)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// EditMessage replaces the text of a message the bot sent earlier.
+func EditMessage(client *whatsmeow.Client, chatJID types.JID, messageID types.MessageID, message string) error {
+	edit := client.BuildEdit(chatJID, messageID, &waProto.Message{
+		Conversation: &message,
+	})
+	_, err := client.SendMessage(context.Background(), chatJID, edit)
+	return err
+}
+
+// SetTyping sends "composing" or "paused" to a chat. It doesn't change the bot's
+// presence, and WhatsApp only shows typing from accounts that are online, so call
+// SetOnline first.
+func SetTyping(client *whatsmeow.Client, chatJID types.JID, typing bool) error {
+	state := types.ChatPresencePaused
+	if typing {
+		state = types.ChatPresenceComposing
+	}
+	return client.SendChatPresence(context.Background(), chatJID, state, types.ChatPresenceMediaText)
+}
+
+// SetOnline sends the account's global presence. Staying "available" keeps the
+// owner's phone from getting push notifications, so only hold it while working.
+func SetOnline(client *whatsmeow.Client, online bool) error {
+	presence := types.PresenceUnavailable
+	if online {
+		presence = types.PresenceAvailable
+	}
+	return client.SendPresence(context.Background(), presence)
+}
+
+// MarkRead sends a read receipt (blue ticks) for one incoming message.
+func MarkRead(client *whatsmeow.Client, messageContext *MessageContext) error {
+	return client.MarkRead(
+		context.Background(),
+		[]types.MessageID{messageContext.MessageID},
+		messageContext.Timestamp,
+		messageContext.ChatID,
+		messageContext.SenderID,
+	)
+}
+
+func React(client *whatsmeow.Client, messageContext *MessageContext, emoji string) error {
+	reaction := client.BuildReaction(messageContext.ChatID, messageContext.SenderID, messageContext.MessageID, emoji)
+	_, err := client.SendMessage(context.Background(), messageContext.ChatID, reaction)
+	return err
+}
+
+func UploadMedia(client *whatsmeow.Client, data []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error) {
+	resp, err := client.Upload(context.Background(), data, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SendSticker sends an uploaded WebP of the given size as a sticker.
+func SendSticker(client *whatsmeow.Client, chatJID types.JID, sticker *whatsmeow.UploadResponse, width, height int) error {
+	msg := &waProto.Message{
+		StickerMessage: &waProto.StickerMessage{
+			URL:           proto.String(sticker.URL),
+			DirectPath:    proto.String(sticker.DirectPath),
+			MediaKey:      sticker.MediaKey,
+			FileEncSHA256: sticker.FileEncSHA256,
+			FileSHA256:    sticker.FileSHA256,
+			FileLength:    proto.Uint64(sticker.FileLength),
+			Mimetype:      proto.String("image/webp"),
+			Width:         proto.Uint32(uint32(width)),
+			Height:        proto.Uint32(uint32(height)),
+		},
+	}
+	_, err := client.SendMessage(context.Background(), chatJID, msg)
+	return err
+}
+
+// SendAudio sends an uploaded audio file to be played inline (not as a voice note).
+func SendAudio(client *whatsmeow.Client, chatJID types.JID, audio *whatsmeow.UploadResponse, mimetype string) error {
+	msg := &waProto.Message{
+		AudioMessage: &waProto.AudioMessage{
+			URL:           proto.String(audio.URL),
+			DirectPath:    proto.String(audio.DirectPath),
+			MediaKey:      audio.MediaKey,
+			FileEncSHA256: audio.FileEncSHA256,
+			FileSHA256:    audio.FileSHA256,
+			FileLength:    proto.Uint64(audio.FileLength),
+			Mimetype:      proto.String(mimetype),
+		},
+	}
+	_, err := client.SendMessage(context.Background(), chatJID, msg)
 	return err
 }
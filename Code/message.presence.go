@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// WhatsApp drops a typing indicator after about 25 seconds, so keep renewing it.
+	typingRefresh = 10 * time.Second
+
+	reactionWorking = "⏳"
+	reactionDone    = "✅"
+	reactionFailed  = "❌"
+)
+
+// workCount counts the work in progress across chats, so the bot shows as
+// online while any of it runs and goes offline when the last piece is done.
+type workCount struct {
+	mu     sync.Mutex
+	active int
+}
+
+// workIndicator lets a chat see that the bot picked up a message: the message is
+// marked read, the bot shows as typing until Done, and with Config.ReactToCommands
+// the message gets ⏳ and then ✅ or ❌. Failures here are only logged.
+type workIndicator struct {
+	bot   *Bot
+	msg   *MessageContext
+	react bool
+	stop  chan struct{}
+	done  chan struct{} // closed when keepTyping returns
+	once  sync.Once
+}
+
+func (b *Bot) startWork(ctx *MessageContext) *workIndicator {
+	w := &workIndicator{
+		bot:   b,
+		msg:   ctx,
+		react: b.Config().ReactToCommands,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	b.work.mu.Lock()
+	if b.work.active == 0 {
+		if err := b.Messenger.SetOnline(true); err != nil {
+			fmt.Printf("Failed to set presence: %v\n", err)
+		}
+	}
+	b.work.active++
+	b.work.mu.Unlock()
+
+	if err := b.Messenger.MarkRead(ctx); err != nil {
+		fmt.Printf("Failed to mark message as read: %v\n", err)
+	}
+	if w.react {
+		if err := b.Messenger.React(ctx, reactionWorking); err != nil {
+			fmt.Printf("Failed to react: %v\n", err)
+		}
+	}
+
+	go w.keepTyping()
+	return w
+}
+
+func (w *workIndicator) keepTyping() {
+	defer close(w.done)
+	ticker := time.NewTicker(typingRefresh)
+	defer ticker.Stop()
+	for {
+		if err := w.bot.Messenger.SetTyping(w.msg.ChatID, true); err != nil {
+			fmt.Printf("Failed to send typing indicator: %v\n", err)
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-w.bot.Shutdown.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Done clears the typing indicator, goes offline if nothing else is running and,
+// if enabled, swaps ⏳ for the outcome. Only the first call has any effect.
+func (w *workIndicator) Done(success bool) {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done // so a late "composing" can't land after "paused"
+		if err := w.bot.Messenger.SetTyping(w.msg.ChatID, false); err != nil {
+			fmt.Printf("Failed to clear typing indicator: %v\n", err)
+		}
+
+		work := &w.bot.work
+		work.mu.Lock()
+		work.active--
+		if work.active == 0 {
+			if err := w.bot.Messenger.SetOnline(false); err != nil {
+				fmt.Printf("Failed to set presence: %v\n", err)
+			}
+		}
+		work.mu.Unlock()
+
+		if !w.react {
+			return
+		}
+		emoji := reactionDone
+		if !success {
+			emoji = reactionFailed
+		}
+		if err := w.bot.Messenger.React(w.msg, emoji); err != nil {
+			fmt.Printf("Failed to react: %v\n", err)
+		}
+	})
+}
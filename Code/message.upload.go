@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// WhatsApp keeps uploaded media around for a while but not forever; re-upload
+// anything older than this instead of sending a link that may be gone.
+const mediaUploadTTL = 7 * 24 * time.Hour
+
+// uploadCached uploads data, or reuses the upload of the same bytes if it is recent.
+func (b *Bot) uploadCached(ctx context.Context, data []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error) {
+	hash := sha256.Sum256(data)
+
+	cached, uploadedAt, ok, err := b.DB.GetMediaUpload(ctx, hash[:], mediaType)
+	if err != nil {
+		fmt.Printf("Failed to read media upload cache: %v\n", err)
+	}
+	if ok && time.Since(uploadedAt) < mediaUploadTTL {
+		return cached, nil
+	}
+
+	upload, err := b.Messenger.Upload(data, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	if len(upload.FileSHA256) == 0 {
+		upload.FileSHA256 = hash[:]
+	}
+	if err := b.DB.SaveMediaUpload(ctx, upload, mediaType, time.Now()); err != nil {
+		fmt.Printf("Failed to cache media upload: %v\n", err)
+	}
+	return upload, nil
+}
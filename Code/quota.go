@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// QuotaConfig limits how often and how much people can use the LLM commands.
+// Zero fields use defaultQuotas; a negative value turns that limit off.
+type QuotaConfig struct {
+	UserBurst           float64 `json:"UserBurst"`         // summaries one person can ask for back to back
+	UserRefillMinutes   float64 `json:"UserRefillMinutes"` // minutes until they can ask for one more
+	ChatBurst           float64 `json:"ChatBurst"`         // same, shared by everyone in a chat
+	ChatRefillMinutes   float64 `json:"ChatRefillMinutes"`
+	ReasonBurst         float64 `json:"ReasonBurst"` // --reason requests per person, on top of the above
+	ReasonRefillMinutes float64 `json:"ReasonRefillMinutes"`
+	UserDailyTokens     int     `json:"UserDailyTokens"`
+	ChatDailyTokens     int     `json:"ChatDailyTokens"`
+	UserDailyCost       float64 `json:"UserDailyCost"` // USD
+	ChatDailyCost       float64 `json:"ChatDailyCost"` // USD
+}
+
+var defaultQuotas = QuotaConfig{
+	UserBurst:           3,
+	UserRefillMinutes:   10,
+	ChatBurst:           10,
+	ChatRefillMinutes:   3,
+	ReasonBurst:         1,
+	ReasonRefillMinutes: 60,
+	UserDailyTokens:     400000,
+	ChatDailyTokens:     2000000,
+	UserDailyCost:       0.20,
+	ChatDailyCost:       1.00,
+}
+
+// QuotaLimits returns the configured quotas with defaults filled in.
+func (c *Config) QuotaLimits() QuotaConfig {
+	q := c.Quotas
+	pick := func(v *float64, def float64) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	pickInt := func(v *int, def int) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	pick(&q.UserBurst, defaultQuotas.UserBurst)
+	pick(&q.UserRefillMinutes, defaultQuotas.UserRefillMinutes)
+	pick(&q.ChatBurst, defaultQuotas.ChatBurst)
+	pick(&q.ChatRefillMinutes, defaultQuotas.ChatRefillMinutes)
+	pick(&q.ReasonBurst, defaultQuotas.ReasonBurst)
+	pick(&q.ReasonRefillMinutes, defaultQuotas.ReasonRefillMinutes)
+	pickInt(&q.UserDailyTokens, defaultQuotas.UserDailyTokens)
+	pickInt(&q.ChatDailyTokens, defaultQuotas.ChatDailyTokens)
+	pick(&q.UserDailyCost, defaultQuotas.UserDailyCost)
+	pick(&q.ChatDailyCost, defaultQuotas.ChatDailyCost)
+	return q
+}
+
+// QuotaRequest is one LLM command about to run.
+type QuotaRequest struct {
+	User          types.JID
+	Chat          types.JID
+	Reason        bool
+	EstimatedCost float64
+}
+
+func (r QuotaRequest) userKey() string { return "user:" + r.User.ToNonAD().String() }
+func (r QuotaRequest) chatKey() string { return "chat:" + r.Chat.String() }
+
+func quotaDay(now time.Time) string {
+	return now.Format("2006-01-02")
+}
+
+// checkQuota returns "" if req may run, otherwise a reply explaining why not.
+// A request that passes has already used up its token in each bucket.
+// The owner is never limited.
+func (b *Bot) checkQuota(ctx context.Context, req QuotaRequest, now time.Time) (string, error) {
+	if b.isOwner(req.User) {
+		return "", nil
+	}
+	q := b.Config().QuotaLimits()
+	day := quotaDay(now)
+
+	tokens, cost, err := b.DB.DailyQuotaUsage(ctx, req.userKey(), day)
+	if err != nil {
+		return "", err
+	}
+	if (q.UserDailyTokens > 0 && tokens >= q.UserDailyTokens) || (q.UserDailyCost > 0 && cost+req.EstimatedCost > q.UserDailyCost) {
+		return "You've used up your summaries for today, they come back at midnight.", nil
+	}
+
+	tokens, cost, err = b.DB.DailyQuotaUsage(ctx, req.chatKey(), day)
+	if err != nil {
+		return "", err
+	}
+	if (q.ChatDailyTokens > 0 && tokens >= q.ChatDailyTokens) || (q.ChatDailyCost > 0 && cost+req.EstimatedCost > q.ChatDailyCost) {
+		return "This chat has used up its summaries for today, they come back at midnight.", nil
+	}
+
+	buckets, replies := quotaBuckets(req, q)
+	if len(buckets) == 0 {
+		return "", nil
+	}
+
+	denied, wait, err := b.DB.TakeQuotaBuckets(ctx, buckets, now)
+	if err != nil {
+		return "", err
+	}
+	if denied >= 0 {
+		return fmt.Sprintf(replies[denied], formatWait(wait)), nil
+	}
+	return "", nil
+}
+
+// quotaBuckets returns the rate limit buckets req takes a token from, with the
+// reply for when each one is empty.
+func quotaBuckets(req QuotaRequest, q QuotaConfig) ([]QuotaBucket, []string) {
+	var buckets []QuotaBucket
+	var replies []string
+	add := func(key string, burst, refillMinutes float64, reply string) {
+		if burst > 0 && refillMinutes > 0 {
+			buckets = append(buckets, QuotaBucket{Key: key, Burst: burst, Rate: 1 / (refillMinutes * 60)})
+			replies = append(replies, reply)
+		}
+	}
+	add(req.userKey(), q.UserBurst, q.UserRefillMinutes, "Easy there! You can ask for another summary in %s.")
+	add(req.chatKey(), q.ChatBurst, q.ChatRefillMinutes, "This chat is asking for a lot of summaries, try again in %s.")
+	if req.Reason {
+		add("reason:"+req.userKey(), q.ReasonBurst, q.ReasonRefillMinutes, "--reason is expensive, you can use it again in %s. It works without it too!")
+	}
+	return buckets, replies
+}
+
+// refundQuota gives back the tokens checkQuota took, for requests that failed
+// before spending anything.
+func (b *Bot) refundQuota(ctx context.Context, req QuotaRequest) error {
+	if b.isOwner(req.User) {
+		return nil
+	}
+	buckets, _ := quotaBuckets(req, b.Config().QuotaLimits())
+	return b.DB.RefundQuotaBuckets(ctx, buckets)
+}
+
+// recordQuota adds what a request actually spent to today's user and chat totals.
+func (b *Bot) recordQuota(ctx context.Context, req QuotaRequest, tokens int, cost float64, now time.Time) error {
+	if tokens == 0 && cost == 0 {
+		return nil
+	}
+	return b.DB.AddDailyQuotaUsage(ctx, quotaDay(now), []string{req.userKey(), req.chatKey()}, tokens, cost)
+}
+
+// formatWait rounds a wait up to something readable like "40s", "7m" or "1h 5m".
+func formatWait(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int((d+time.Minute-1)/time.Minute))
+	default:
+		d = (d + time.Minute - 1).Truncate(time.Minute)
+		return fmt.Sprintf("%dh %dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+}
+
+// formatSpend shows a cost against its daily cap, if there is one.
+func formatSpend(cost, limit float64) string {
+	if limit <= 0 {
+		return fmt.Sprintf("$%.3f", cost)
+	}
+	return fmt.Sprintf("$%.3f of $%.2f", cost, limit)
+}
+
+// ? ----------------------------------------------Quota Command----------------------------------------------
+
+// handleQuotaCommand shows the sender's usage today. The owner can also run
+// "--quota reset [@user...]" to clear this chat's limits and those of anyone mentioned.
+func (b *Bot) handleQuotaCommand(ctx *MessageContext, words []string) {
+	rootCtx := b.Shutdown.Context()
+	now := ctx.Timestamp
+	req := QuotaRequest{User: b.canonicalJID(ctx.SenderID), Chat: ctx.ChatID}
+
+	if len(words) > 1 && strings.ToLower(words[1]) == "reset" {
+		if !b.isOwner(ctx.SenderID) {
+			b.Messenger.SendReplyMessage(ctx, "Only the owner can reset quotas.")
+			return
+		}
+		keys := []string{req.chatKey()}
+		for _, mention := range ctx.Mentions {
+			if jid, err := types.ParseJID(mention); err == nil {
+				keys = append(keys, QuotaRequest{User: b.canonicalJID(jid)}.userKey())
+			}
+		}
+		if err := b.DB.ResetQuotas(rootCtx, keys); err != nil {
+			fmt.Printf("Failed to reset quotas: %v\n", err)
+			b.Messenger.SendReplyMessage(ctx, "Failed to reset quotas.")
+			return
+		}
+		b.Messenger.SendReplyMessage(ctx, fmt.Sprintf("Quotas reset for this chat and %d user(s).", len(keys)-1))
+		return
+	}
+
+	q := b.Config().QuotaLimits()
+	userTokens, userCost, err := b.DB.DailyQuotaUsage(rootCtx, req.userKey(), quotaDay(now))
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, "Failed to load quotas.")
+		return
+	}
+	chatTokens, chatCost, err := b.DB.DailyQuotaUsage(rootCtx, req.chatKey(), quotaDay(now))
+	if err != nil {
+		b.Messenger.SendReplyMessage(ctx, "Failed to load quotas.")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*Today's usage*\n")
+	sb.WriteString(fmt.Sprintf("- You: %d tokens, %s\n", userTokens, formatSpend(userCost, q.UserDailyCost)))
+	sb.WriteString(fmt.Sprintf("- This chat: %d tokens, %s", chatTokens, formatSpend(chatCost, q.ChatDailyCost)))
+	if b.isOwner(ctx.SenderID) {
+		sb.WriteString("\n\nYou're the owner, so none of this limits you.")
+	}
+	b.Messenger.SendReplyMessage(ctx, sb.String())
+}
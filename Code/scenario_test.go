@@ -0,0 +1,1276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestVersionAndInfoCommands(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--version"))
+	h.ExpectSent("test version")
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-i"))
+	h.ExpectSent("test info")
+}
+
+func TestCommandsOutsideGroupsAreIgnored(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testMember, testMember, "Ana", "--version"))
+	h.ExpectNothingSent()
+}
+
+func TestMessagesFromBeforeStartAreIgnored(t *testing.T) {
+	h := newTestHarness(t)
+
+	evt := h.Text(testGroup, testMember, "Ana", "--version")
+	evt.Info.Timestamp = h.bot.StartTime.Add(-time.Minute)
+	h.Deliver(evt)
+	h.ExpectNothingSent()
+}
+
+func TestOnlyOwnerCanReloadConfigs(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--reload-json"))
+	h.ExpectSent("Only the owner can reload configs.")
+}
+
+func TestAliasIsUsedForStoredMessages(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	reply := h.ExpectSent("Alias has been saved.")
+	if reply.ReplyTo == "" {
+		t.Fatalf("expected alias confirmation to quote the command")
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "see you friday"))
+	h.ExpectNothingSent()
+
+	stored := h.Stored(testGroup)
+	if len(stored) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(stored))
+	}
+	if stored[0].SenderName != "Anita" || stored[0].Text != "see you friday" {
+		t.Fatalf("unexpected stored message: %+v", stored[0])
+	}
+}
+
+func TestImageIsStoredWithPlaceholderDescription(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+
+	h.Deliver(h.Image(testGroup, testMember, "Ana", []byte{0xca, 0xfe}))
+
+	stored := h.Stored(testGroup)
+	if len(stored) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(stored))
+	}
+	if stored[0].MediaDescription != "Processing image..." {
+		t.Fatalf("unexpected media description %q", stored[0].MediaDescription)
+	}
+}
+
+func TestSummarizeWithStyle(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(
+		h.Text(testGroup, testMember, "Ana", "we ship on friday"),
+		h.Text(testGroup, testMember, "Ana", "Luis does the release notes"),
+	)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--summarize 20 --style actions --short"))
+	sent := h.WaitSent(1)
+	if sent[0].Text != "test summary" || sent[0].ReplyTo == "" {
+		t.Fatalf("unexpected summary reply: %+v", sent[0])
+	}
+
+	requests := h.llm.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 LLM request, got %d", len(requests))
+	}
+	prompt := requests[0].Messages[len(requests[0].Messages)-1].Content
+	for _, want := range []string{"test actions", "test short", "Anita: we ship on friday", "Anita: Luis does the release notes"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestSummarizeRejectsUnknownStyle(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--summarize --style poem"))
+	h.ExpectSent(`unknown style "poem"`)
+}
+
+func TestSummarizeSinceMe(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(
+		h.Text(testGroup, testMember, "Ana", "--alias Anita"),
+		h.Text(testGroup, testOwner, "Owner", "--alias Boss"),
+	)
+	h.Sent()
+	h.Deliver(
+		h.Text(testGroup, testOwner, "Owner", "before you left"),
+		h.Text(testGroup, testMember, "Ana", "brb"),
+		h.Text(testGroup, testOwner, "Owner", "we moved the meeting to monday"),
+	)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--summarize --since-me"))
+	h.WaitSent(1)
+
+	prompt := h.llm.Requests()[0].Messages[1].Content
+	if !strings.Contains(prompt, "Boss: we moved the meeting to monday") {
+		t.Errorf("prompt is missing the message after the asker's last one:\n%s", prompt)
+	}
+	for _, unwanted := range []string{"before you left", "brb"} {
+		if strings.Contains(prompt, unwanted) {
+			t.Errorf("prompt should not include %q:\n%s", unwanted, prompt)
+		}
+	}
+}
+
+func TestSummarizeByDuration(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "old news"))
+	h.clock = h.clock.Add(2 * time.Hour)
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "fresh news"))
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s 1h"))
+	h.WaitSent(1)
+
+	prompt := h.llm.Requests()[0].Messages[1].Content
+	if !strings.Contains(prompt, "fresh news") || strings.Contains(prompt, "old news") {
+		t.Errorf("expected only the last hour in the prompt:\n%s", prompt)
+	}
+}
+
+func TestSummarizeSinceClockTimeAndDate(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.StartTime = time.Date(2026, 10, 17, 20, 0, 0, 0, time.Local)
+	h.clock = h.bot.StartTime
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "before nine"))
+	h.clock = h.clock.Add(90 * time.Minute)
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "after nine"))
+
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "-s 9pm"))
+	h.WaitSent(1)
+	if prompt := h.llm.Requests()[0].Messages[1].Content; !strings.Contains(prompt, "after nine") || strings.Contains(prompt, "before nine") {
+		t.Errorf("expected only what was said since 9pm:\n%s", prompt)
+	}
+
+	h.clock = time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "good morning"))
+
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "-s yesterday 21:00 --short"))
+	h.WaitSent(1)
+	if prompt := h.llm.Requests()[1].Messages[1].Content; !strings.Contains(prompt, "after nine") || !strings.Contains(prompt, "good morning") || strings.Contains(prompt, "before nine") {
+		t.Errorf("expected everything since yesterday at 21:00:\n%s", prompt)
+	}
+
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "-s 2026-10-18"))
+	h.WaitSent(1)
+	if prompt := h.llm.Requests()[2].Messages[1].Content; !strings.Contains(prompt, "good morning") || strings.Contains(prompt, "after nine") {
+		t.Errorf("expected only today's messages:\n%s", prompt)
+	}
+}
+
+func TestSummarizeChunksLongChats(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.Models = map[string]ModelLimits{
+		deepSeekChatModel: {ChunkTokens: 60},
+	}
+	// Tiny chunks would make the partial summaries outgrow the chat, so lift the caps.
+	h.bot.config.MaxCostPerSummary = 1
+	h.bot.config.Quotas = QuotaConfig{UserDailyCost: -1, ChatDailyCost: -1}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	for i := 0; i < 12; i++ {
+		h.Deliver(h.Text(testGroup, testMember, "Ana", strings.Repeat("long message ", 4)))
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s 12"))
+	if sent := h.WaitSent(1); sent[0].Text != "test summary" {
+		t.Fatalf("unexpected reply: %+v", sent[0])
+	}
+
+	requests := h.llm.Requests()
+	if len(requests) < 3 {
+		t.Fatalf("expected chunk summaries before the final one, got %d requests", len(requests))
+	}
+	final := requests[len(requests)-1].Messages[1].Content
+	if !strings.Contains(final, "summaries of its parts") || !strings.Contains(final, "Part 1 of") {
+		t.Errorf("final prompt should merge partial summaries:\n%s", final)
+	}
+	for _, req := range requests[:len(requests)-1] {
+		if req.MaxTokens != chunkSummaryTokens {
+			t.Errorf("chunk request should cap output at %d tokens, got %d", chunkSummaryTokens, req.MaxTokens)
+		}
+	}
+}
+
+func TestSummarizeRefusesOverCostLimit(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.MaxCostPerSummary = 0.000001
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	sent := h.WaitSent(1)
+	if !strings.Contains(sent[0].Text, "over the $") {
+		t.Fatalf("expected a cost limit reply, got %+v", sent[0])
+	}
+	if n := len(h.llm.Requests()); n != 0 {
+		t.Fatalf("expected no LLM calls, got %d", n)
+	}
+}
+
+func TestSummarizeRateLimitsUsersButNotOwner(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.Quotas = QuotaConfig{UserBurst: 1, ReasonBurst: -1}
+
+	h.Deliver(
+		h.Text(testGroup, testMember, "Ana", "--alias Anita"),
+		h.Text(testGroup, testOwner, "Owner", "--alias Boss"),
+	)
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	h.WaitSent(1)
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); !strings.Contains(sent[0].Text, "another summary in 10m") {
+		t.Fatalf("expected the second request to be rate limited, got %+v", sent[0])
+	}
+
+	for i := 0; i < 3; i++ {
+		h.Deliver(h.Text(testGroup, testOwner, "Owner", "-s"))
+		if sent := h.WaitSent(1); sent[0].Text != "test summary" {
+			t.Fatalf("owner should not be limited, got %+v", sent[0])
+		}
+	}
+
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "--quota reset", testMember))
+	h.ExpectSent("Quotas reset for this chat and 1 user(s)")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); sent[0].Text != "test summary" {
+		t.Fatalf("expected the reset to let Ana summarize again, got %+v", sent[0])
+	}
+}
+
+func TestSummarizeDailyCostCap(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.Quotas = QuotaConfig{UserDailyCost: 0.001}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); !strings.Contains(sent[0].Text, "used up your summaries for today") {
+		t.Fatalf("expected the daily cap reply, got %+v", sent[0])
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	h := newTestHarness(t)
+	if err := h.bot.DB.SaveGroup(h.ctx, GroupMeta{ChatJID: testGroup.String(), Name: "Trip planning", UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	h.WaitSent(1)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--usage"))
+	report := h.ExpectSent("*Usage in this chat*")
+	for _, want := range []string{"Today: 1 call, 120 tokens", "This month: 1 call", "*Top users today*\n- Anita: 1 call", "*Top users this week*\n- Anita", "*Top users this month*\n- Anita"} {
+		if !strings.Contains(report.Text, want) {
+			t.Errorf("report is missing %q:\n%s", want, report.Text)
+		}
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--usage all"))
+	h.ExpectSent("Only the owner")
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "--usage all"))
+	report = h.ExpectSent("*Usage across all chats*")
+	if !strings.Contains(report.Text, "- Trip planning: 1 call") || strings.Contains(report.Text, testGroup.String()) {
+		t.Errorf("owner report should list the chat by name:\n%s", report.Text)
+	}
+}
+
+func TestLongSummaryIsEditedIntoPlaceholderAndSplit(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.MaxReplyLength = 200
+	h.llm.Reply = "## Topics\n\n" + strings.Repeat("**Plans** for the trip were discussed. ", 4) + "\n\n" + strings.Repeat("Someone asked about *tickets*. ", 5)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s --long"))
+
+	sent := h.WaitSent(2)
+	if len(sent) < 2 {
+		t.Fatalf("expected a multi-part reply, got %+v", sent)
+	}
+	if sent[0].Edits == 0 || sent[0].ReplyTo == "" || !strings.HasPrefix(sent[0].Text, "(1/") {
+		t.Errorf("first part should replace the placeholder reply: %+v", sent[0])
+	}
+	if !strings.Contains(sent[0].Text, "*Topics*") || !strings.Contains(sent[0].Text, "*Plans*") {
+		t.Errorf("markdown should be converted: %q", sent[0].Text)
+	}
+	last := sent[len(sent)-1]
+	if !strings.Contains(last.Text, "_tickets_") || !strings.HasPrefix(last.Text, "(") {
+		t.Errorf("unexpected last part: %q", last.Text)
+	}
+}
+
+func TestSummarizeShowsProgress(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.ReactToCommands = true
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+
+	command := h.Text(testGroup, testMember, "Ana", "-s")
+	h.Deliver(command)
+	h.WaitSent(1)
+	h.bot.Shutdown.Drain(time.Second)
+
+	m := h.messenger
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.read) != 1 || m.read[0] != command.Info.ID {
+		t.Errorf("expected the command to be marked read, got %v", m.read)
+	}
+	if len(m.typing) < 2 || !m.typing[0] || m.typing[len(m.typing)-1] {
+		t.Errorf("expected typing to start and then stop, got %v", m.typing)
+	}
+	if got := strings.Join(m.reactions[command.Info.ID], ""); got != reactionWorking+reactionDone {
+		t.Errorf("expected ⏳ then ✅, got %q", got)
+	}
+	if len(m.online) != 2 || !m.online[0] || m.online[1] {
+		t.Errorf("expected to go online for the summary and offline after it, got %v", m.online)
+	}
+}
+
+func TestUndeliveredReplyIsReportedAsFailed(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.ReactToCommands = true
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--version"))
+	h.ExpectSent("test version")
+	h.messenger.failAfter = 1
+
+	question := h.Text(testGroup, testMember, "Ana", "@"+testBotLID.User+" what's up?", testBotLID)
+	h.Deliver(question)
+	h.bot.Shutdown.Drain(time.Second)
+
+	m := h.messenger
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := strings.Join(m.reactions[question.Info.ID], ""); got != reactionWorking+reactionFailed {
+		t.Errorf("expected ⏳ then ❌ when the reply can't be sent, got %q", got)
+	}
+}
+
+func TestStickersAreUploadedOnceAndPickedByMood(t *testing.T) {
+	h := newTestHarness(t)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Happy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Happy", "smile.webp"), testWebP(512, 512), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a sticker"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := LoadStickerLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.Len() != 1 || strings.Join(lib.Moods(), ",") != "happy" {
+		t.Fatalf("unexpected library: %d stickers, moods %v", lib.Len(), lib.Moods())
+	}
+	if mood := lib.MoodIn("@bot estoy HAPPY!"); mood != "happy" {
+		t.Errorf("expected mood happy, got %q", mood)
+	}
+	h.bot.Stickers = lib
+
+	for i := 0; i < 2; i++ {
+		if err := h.bot.sendSticker(context.Background(), testGroup, "happy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := h.Sent(); len(sent) != 2 || !strings.HasSuffix(sent[0].Text, " 512x512]") {
+		t.Fatalf("expected 2 stickers, got %+v", sent)
+	}
+	if uploads := h.messenger.Uploads(); uploads != 1 {
+		t.Errorf("expected the second sticker to reuse the upload, got %d uploads", uploads)
+	}
+
+	h.bot.Stickers = nil
+	if err := h.bot.sendSticker(context.Background(), testGroup, ""); err == nil {
+		t.Errorf("expected an error without stickers")
+	}
+}
+
+// testWebP returns the header of an extended (VP8X) WebP with the given canvas size.
+func testWebP(width, height int) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00")
+	w, h := width-1, height-1
+	return append(data, byte(w), byte(w>>8), byte(w>>16), byte(h), byte(h>>8), byte(h>>16))
+}
+
+func TestWebPStickersKeepTheirOwnSize(t *testing.T) {
+	lossy := []byte("RIFF\x00\x00\x00\x00WEBPVP8 \x00\x00\x00\x00\x00\x00\x00\x9d\x01\x2a\x00\x02\x2c\x01")
+	lossless := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f\xff\x40\x4a\x00\x00\x00\x00\x00\x00")
+	for _, tc := range []struct {
+		data          []byte
+		width, height int
+	}{
+		{testWebP(640, 320), 640, 320},
+		{lossy, 512, 300},
+		{lossless, 256, 298},
+	} {
+		width, height, err := webpSize(tc.data)
+		if err != nil || width != tc.width || height != tc.height {
+			t.Errorf("webpSize(%q) = %dx%d, %v; want %dx%d", tc.data[12:16], width, height, err, tc.width, tc.height)
+		}
+	}
+	if _, _, err := webpSize([]byte("RIFF-not-really-a-webp-file-at-all")); err == nil {
+		t.Errorf("expected an error for a broken WebP")
+	}
+
+	h := newTestHarness(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wide.webp"), testWebP(640, 320), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.webp"), []byte("RIFF-broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := LoadStickerLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.Len() != 1 {
+		t.Fatalf("expected the broken WebP to be skipped, got %d stickers", lib.Len())
+	}
+	h.bot.Stickers = lib
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "", testBotLID))
+	h.ExpectSentLater(" 640x320]")
+}
+
+func TestAudioTriggersRespectCooldown(t *testing.T) {
+	h := newTestHarness(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("ID3-song"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.config.AudioTriggers = []AudioTrigger{
+		{Phrase: "Bancho, lofi", Files: []string{dir}, CooldownSeconds: 60},
+		{Pattern: `\bpum( x\d)?\b`, Files: []string{filepath.Join(dir, "song.mp3")}},
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "bancho,   LOFI please"))
+	if sent := h.WaitSent(1); len(sent) != 1 || sent[0].Text != "[audio audio/mpeg]" {
+		t.Fatalf("expected one mp3, got %+v", sent)
+	}
+
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "Bancho, lofi"))
+	h.ExpectNothingSent()
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "PUM x3"))
+	later := h.Text(testGroup, testMember, "Ana", "bancho, lofi")
+	later.Info.Timestamp = later.Info.Timestamp.Add(2 * time.Minute)
+	h.Deliver(later)
+	h.bot.Shutdown.Drain(time.Second)
+	if sent := h.WaitSent(2); len(sent) != 2 {
+		t.Fatalf("expected the pattern and the cooled-down phrase to play, got %+v", sent)
+	}
+	if uploads := h.messenger.Uploads(); uploads != 1 {
+		t.Errorf("expected one upload of the shared file, got %d", uploads)
+	}
+}
+
+func TestFailedAudioDoesNotStartCooldown(t *testing.T) {
+	h := newTestHarness(t)
+
+	song := filepath.Join(t.TempDir(), "song.mp3")
+	h.bot.config.AudioTriggers = []AudioTrigger{{Phrase: "Bancho, lofi", Files: []string{song}, CooldownSeconds: 60}}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "bancho, lofi"))
+	h.bot.Shutdown.Drain(time.Second)
+	h.ExpectNothingSent()
+
+	h.bot.Shutdown = NewShutdownCoordinator(context.Background())
+	if err := os.WriteFile(song, []byte("ID3-song"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "bancho, lofi"))
+	if sent := h.WaitSent(1); sent[0].Text != "[audio audio/mpeg]" {
+		t.Fatalf("expected the failed attempt not to hold the cooldown, got %+v", sent)
+	}
+}
+
+func TestExpiredCooldownsArePruned(t *testing.T) {
+	cooldowns := &CooldownCache{until: make(map[string]time.Time)}
+	start := time.Unix(1_700_000_000, 0)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if !takeCooldown(cooldowns, key, start, time.Minute) {
+			t.Fatalf("expected %s to be free", key)
+		}
+	}
+	if takeCooldown(cooldowns, "a", start.Add(30*time.Second), time.Minute) {
+		t.Errorf("expected a to still be cooling down")
+	}
+	if !takeCooldown(cooldowns, "d", start.Add(2*time.Minute), time.Minute) {
+		t.Errorf("expected d to be free")
+	}
+	if len(cooldowns.until) != 1 {
+		t.Errorf("expected only d's cooldown to be kept, got %v", cooldowns.until)
+	}
+}
+
+func TestMentionWithQuestionGetsConversationalReply(t *testing.T) {
+	h := newTestHarness(t)
+	h.llm.Reply = "Ana said **Friday** works."
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Ana"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "friday at 8 works for me"))
+
+	evt := h.newEvent(testGroup, testOwner, "Owner", &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: proto.String("what did @" + testMember.User + " say about friday?"),
+			ContextInfo: &waProto.ContextInfo{
+				MentionedJID:  []string{testMember.String()},
+				StanzaID:      proto.String("BOTMSG00001"),
+				Participant:   proto.String("100000000000099@lid"),
+				QuotedMessage: &waProto.Message{Conversation: proto.String("Soy ese")},
+			},
+		},
+	})
+	msgCtx, err := ParseMessageEvent(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgCtx.QuotedMessageID != "BOTMSG00001" || msgCtx.QuotedText != "Soy ese" {
+		t.Fatalf("quoted message not parsed: %+v", msgCtx)
+	}
+
+	h.bot.replyToMention(msgCtx)
+	sent := h.WaitSent(1)
+	if len(sent) != 1 || sent[0].Text != "Ana said *Friday* works." || sent[0].ReplyTo != msgCtx.MessageID {
+		t.Fatalf("unexpected reply: %+v", sent)
+	}
+
+	requests := h.llm.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 LLM call, got %d", len(requests))
+	}
+	prompt := requests[0].Messages[1].Content
+	for _, want := range []string{"Ana: friday at 8 works for me", "replying to your message:\nSoy ese", "Owner says to you: what did @Ana say about friday?"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestQuotedMessageFromSomeoneElseIsNotTheBots(t *testing.T) {
+	h := newTestHarness(t)
+	h.llm.Reply = "Sounds good."
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Ana"))
+	h.Sent()
+
+	h.Deliver(h.newEvent(testGroup, testOwner, "Owner", &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: proto.String("@" + testBotLID.User + " is this true?"),
+			ContextInfo: &waProto.ContextInfo{
+				MentionedJID:  []string{testBotLID.String()},
+				StanzaID:      proto.String("ANAMSG00001"),
+				Participant:   proto.String(testMember.String()),
+				QuotedMessage: &waProto.Message{Conversation: proto.String("the bot wrote this")},
+			},
+		},
+	}))
+	h.WaitSent(1)
+
+	requests := h.llm.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 LLM call, got %d", len(requests))
+	}
+	prompt := requests[0].Messages[1].Content
+	if !strings.Contains(prompt, "replying to this message from Ana:\nthe bot wrote this") || strings.Contains(prompt, "your message") {
+		t.Errorf("expected the quote to be attributed to Ana:\n%s", prompt)
+	}
+}
+
+func TestBareMentionStillGetsSticker(t *testing.T) {
+	h := newTestHarness(t)
+
+	msgCtx, err := ParseMessageEvent(h.Text(testGroup, testMember, "Ana", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.bot.replyToMention(msgCtx)
+	h.ExpectSentLater("Soy ese")
+	if len(h.llm.Requests()) != 0 {
+		t.Errorf("a bare mention shouldn't call the LLM")
+	}
+}
+
+func TestBotIsAddressedByAnyOfItsJIDs(t *testing.T) {
+	h := newTestHarness(t)
+
+	withDevice := types.NewADJID(testBotPN.User, 0, 12).String()
+	for _, mention := range []string{testBotLID.String(), testBotPN.String(), withDevice, testBotPN.User + "@c.us"} {
+		h.Deliver(h.Text(testGroup, testMember, "Ana", "", mustParseJID(t, mention)))
+		h.ExpectSentLater("Soy ese")
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "", testOwner))
+	h.ExpectNothingSent()
+
+	reply := h.newEvent(testGroup, testMember, "Ana", &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: proto.String("why?"),
+			ContextInfo: &waProto.ContextInfo{
+				StanzaID:    proto.String("BOTMSG00001"),
+				Participant: proto.String(testBotLID.User + ":3@lid"),
+			},
+		},
+	})
+	h.Deliver(reply)
+	h.WaitSent(1)
+	if len(h.llm.Requests()) != 1 {
+		t.Errorf("expected a reply to the bot to get an LLM answer")
+	}
+}
+
+func mustParseJID(t *testing.T, s string) types.JID {
+	t.Helper()
+	jid, err := types.ParseJID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jid
+}
+
+func TestPhoneNumberSendersResolveToTheirLID(t *testing.T) {
+	h := newTestHarness(t)
+	memberPN := types.NewJID("5215522222222", types.DefaultUserServer)
+	ownerPN := types.NewJID("5215511111111", types.DefaultUserServer)
+	h.bot.LIDs = fakeLIDs{memberPN: testMember, ownerPN: testOwner}
+
+	if !h.bot.isOwner(types.NewADJID(ownerPN.User, 0, 7)) {
+		t.Errorf("the owner's phone number on another device should count as the owner")
+	}
+	if h.bot.isOwner(memberPN) {
+		t.Errorf("a member's phone number shouldn't count as the owner")
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.ExpectSent("Alias has been saved.")
+	h.Deliver(h.Text(testGroup, memberPN, "Ana", "same person, other JID"))
+
+	stored := h.Stored(testGroup)
+	if len(stored) != 1 || stored[0].SenderName != "Anita" || stored[0].SenderJID != testMember.String() {
+		t.Fatalf("expected the PN message under the LID alias, got %+v", stored)
+	}
+}
+
+func TestStoredPhoneNumbersMoveToLIDsOnce(t *testing.T) {
+	h := newTestHarness(t)
+	ctx := context.Background()
+	memberPN := types.NewJID("5215522222222", types.DefaultUserServer)
+	day := quotaDay(time.Now())
+
+	// Written before canonical JIDs, under the phone number.
+	db := h.bot.DB
+	if err := db.SetAlias(ctx, testGroup.String(), memberPN.String(), "Anita"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddDailyQuotaUsage(ctx, day, []string{"user:" + memberPN.String()}, 100, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddDailyQuotaUsage(ctx, day, []string{"user:" + testMember.String()}, 50, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertLLMUsage(ctx, LLMUsage{ChatJID: testGroup.String(), RequesterJID: memberPN.String(), Model: deepSeekChatModel, Success: true, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.bot.LIDs = fakeLIDs{memberPN: testMember}
+	if err := h.bot.canonicalizeStoredJIDs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hola"))
+	if stored := h.Stored(testGroup); len(stored) != 1 || stored[0].SenderName != "Anita" {
+		t.Fatalf("expected the phone-number alias to apply to the LID, got %+v", stored)
+	}
+	if tokens, _, err := db.DailyQuotaUsage(ctx, "user:"+testMember.String(), day); err != nil || tokens != 150 {
+		t.Errorf("expected the daily usage to be merged into the LID, got %d (%v)", tokens, err)
+	}
+	totals, err := db.UsageTotals(ctx, "requester_jid", "", time.Time{}, 0)
+	if err != nil || len(totals) != 1 || totals[0].Key != testMember.String() {
+		t.Errorf("expected usage under the LID, got %+v (%v)", totals, err)
+	}
+
+	if err := db.SetAlias(ctx, testGroup.String(), memberPN.String(), "Late"); err != nil {
+		t.Fatal(err)
+	}
+	if pns, err := db.PhoneNumberJIDs(ctx); err != nil || pns != nil {
+		t.Errorf("expected the rewrite to run only once, got %v (%v)", pns, err)
+	}
+}
+
+func TestPhoneNumbersWithoutLIDsMoveOnceLearned(t *testing.T) {
+	h := newTestHarness(t)
+	ctx := context.Background()
+	memberPN := types.NewJID("5215522222222", types.DefaultUserServer)
+	laterPN := types.NewJID("5215533333333", types.DefaultUserServer)
+	laterLID := types.NewJID("99887766554433", types.HiddenUserServer)
+
+	db := h.bot.DB
+	for _, pn := range []types.JID{memberPN, laterPN} {
+		if err := db.AddUserToWhitelist(ctx, pn.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.bot.LIDs = fakeLIDs{memberPN: testMember}
+	if err := h.bot.canonicalizeStoredJIDs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pns, err := db.PhoneNumberJIDs(ctx); err != nil || len(pns) != 1 || pns[0] != laterPN.String() {
+		t.Fatalf("expected the unmapped phone number to be left for later, got %v (%v)", pns, err)
+	}
+
+	// The LID store learns the other mapping on a later connect.
+	h.bot.LIDs = fakeLIDs{memberPN: testMember, laterPN: laterLID}
+	if err := h.bot.canonicalizeStoredJIDs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.IsUserWhitelisted(ctx, laterLID.String()); !ok {
+		t.Errorf("the whitelist entry didn't move to the later LID")
+	}
+	if pns, err := db.PhoneNumberJIDs(ctx); err != nil || pns != nil {
+		t.Errorf("expected the rewrite to be done, got %v (%v)", pns, err)
+	}
+}
+
+func TestAliasShowClearGlobalAndSetForOthers(t *testing.T) {
+	h := newTestHarness(t)
+	otherGroup := types.NewJID("120363000000000002", types.GroupServer)
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias global Ana Banana"))
+	h.ExpectSent("Global alias has been saved.")
+	h.Deliver(h.Text(otherGroup, testMember, "Ana", "hi from elsewhere"))
+	if stored := h.Stored(otherGroup); len(stored) != 1 || stored[0].SenderName != "Ana Banana" {
+		t.Fatalf("expected the global alias in another chat, got %+v", stored)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.ExpectSent("Alias has been saved.")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias show"))
+	reply := h.ExpectSent("Your alias in this chat is *Anita*.")
+	if !strings.Contains(reply.Text, "Your global alias is *Ana Banana*.") {
+		t.Errorf("show should mention the global alias: %q", reply.Text)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias set @"+testOwner.User+" Boss", testOwner))
+	h.ExpectSent("Only group admins can set other people's aliases.")
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "--alias set @"+testMember.User+" Ana B.", testMember))
+	h.ExpectSent("Alias has been saved.")
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--aliases"))
+	h.ExpectSent("- Ana B. (" + testMember.User + ")")
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias clear"))
+	h.ExpectSent("Your alias in this chat has been cleared.")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias clear"))
+	h.ExpectSent("You don't have an alias to clear.")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias show"))
+	h.ExpectSent("so your global alias is used")
+}
+
+func TestSenderNamesFallBackWithoutAlias(t *testing.T) {
+	h := newTestHarness(t)
+	savedPN := types.NewJID("5215533333333", types.DefaultUserServer)
+	savedLID := types.NewJID("100000000000003", types.HiddenUserServer)
+	strangerPN := types.NewJID("5215544444444", types.DefaultUserServer)
+	h.bot.LIDs = fakeLIDs{savedPN: savedLID}
+	h.bot.Contacts = fakeContacts{savedPN: {Found: true, FullName: "Carlos Contact", PushName: "carlitos"}}
+
+	h.Deliver(
+		h.Text(testGroup, testMember, "Ana", "only a push name"),
+		h.Text(testGroup, savedLID, "carlitos", "saved in contacts under my phone number"),
+		h.Text(testGroup, strangerPN, "", "no name at all"),
+	)
+
+	stored := h.Stored(testGroup)
+	if len(stored) != 3 {
+		t.Fatalf("expected every message to be stored, got %+v", stored)
+	}
+	for i, want := range []string{"Ana", "Carlos Contact", "+" + strangerPN.User} {
+		if stored[i].SenderName != want {
+			t.Errorf("message %d: expected sender %q, got %q", i, want, stored[i].SenderName)
+		}
+	}
+}
+
+func TestGroupEventsUpdateMetadataAndContext(t *testing.T) {
+	h := newTestHarness(t)
+	newcomer := types.NewJID("100000000000004", types.HiddenUserServer)
+
+	h.bot.handleJoinedGroup(h.ctx, &events.JoinedGroup{GroupInfo: types.GroupInfo{
+		JID:       testGroup,
+		GroupName: types.GroupName{Name: "Trip planning"},
+		Participants: []types.GroupParticipant{
+			{JID: testOwner, IsSuperAdmin: true},
+			{JID: testMember},
+		},
+	}})
+	if h.bot.isGroupAdmin(h.ctx, testGroup, testMember) || !h.bot.isGroupAdmin(h.ctx, testGroup, testOwner) {
+		t.Fatalf("admin status not taken from the group info")
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.Sent()
+	h.bot.handleGroupInfo(h.ctx, &events.GroupInfo{JID: testGroup, Sender: &testMember, Timestamp: h.tick(), Join: []types.JID{newcomer}})
+	h.bot.handleGroupInfo(h.ctx, &events.GroupInfo{JID: testGroup, Sender: &testOwner, Timestamp: h.tick(), Promote: []types.JID{testMember}})
+	h.bot.handleGroupInfo(h.ctx, &events.GroupInfo{JID: testGroup, Timestamp: h.tick(), Leave: []types.JID{newcomer}})
+
+	if !h.bot.isGroupAdmin(h.ctx, testGroup, testMember) {
+		t.Errorf("promotion wasn't recorded")
+	}
+	meta, _, err := h.bot.DB.GetGroup(h.ctx, testGroup.String())
+	if err != nil || len(meta.Participants) != 2 {
+		t.Fatalf("expected the newcomer to be gone again, got %+v (%v)", meta, err)
+	}
+
+	stored := h.Stored(testGroup)
+	if len(stored) != 2 || stored[0].Text != "Anita added "+newcomer.User || stored[1].Text != newcomer.User+" left" || stored[0].SenderName != systemSenderName {
+		t.Fatalf("unexpected system lines: %+v", stored)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	h.WaitSent(1)
+	if prompt := h.llm.Requests()[0].Messages[1].Content; !strings.Contains(prompt, `Conversation in the group "Trip planning":`) {
+		t.Errorf("summary prompt should name the group:\n%s", prompt)
+	}
+}
+
+func TestJoiningUnknownGroupAsksOwner(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.GroupWhitelist = nil
+	h.bot.Prompts().OnboardingMessage = "hello group"
+
+	h.bot.handleJoinedGroup(h.ctx, &events.JoinedGroup{Sender: &testMember, GroupInfo: types.GroupInfo{
+		JID:       testGroup,
+		GroupName: types.GroupName{Name: "Trip planning"},
+	}})
+	ask := h.ExpectSent("--approve " + testGroup.User)
+	if ask.Chat != testOwner || !strings.Contains(ask.Text, "Trip planning") {
+		t.Fatalf("expected a DM to the owner naming the group, got %+v", ask)
+	}
+
+	// Only the owner's DMs count.
+	h.Deliver(h.Text(testMember, testMember, "Ana", "--approve "+testGroup.User))
+	h.ExpectNothingSent()
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--approve"))
+	sent := h.Sent()
+	if len(sent) != 2 || sent[0].Chat != testGroup || sent[0].Text != "hello group" || !strings.Contains(sent[1].Text, "Approved *Trip planning*") {
+		t.Fatalf("expected the onboarding message and a confirmation, got %+v", sent)
+	}
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); !ok {
+		t.Errorf("approved group wasn't whitelisted")
+	}
+	if pending, _ := h.bot.DB.ListPendingGroups(h.ctx); len(pending) != 0 {
+		t.Errorf("approved group is still pending: %+v", pending)
+	}
+}
+
+func TestPendingGroupsAreOnlyStored(t *testing.T) {
+	h := newTestHarness(t)
+	pending := types.NewJID("120363000000000002", types.GroupServer)
+	if err := h.bot.DB.AddPendingGroup(h.ctx, pending.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Deliver(h.Text(pending, testMember, "Ana", "hello"))
+	h.Deliver(h.Text(pending, testMember, "Ana", "-s"))
+	h.Deliver(h.Text(pending, testMember, "Ana", "@bot hi", testBotLID))
+	h.bot.Shutdown.Drain(time.Second)
+	h.ExpectNothingSent()
+	if stored := h.Stored(pending); len(stored) != 2 {
+		t.Errorf("expected the pending group's messages to be stored, got %+v", stored)
+	}
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--approve 120363000000000009"))
+	h.ExpectSent("isn't waiting for approval")
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, "120363000000000009@g.us"); ok {
+		t.Errorf("a group that wasn't pending got whitelisted")
+	}
+}
+
+func TestDMRepliesOnlyForAllowedUsers(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testMember, testMember, "Ana", "@bot hi", testBotLID))
+	h.bot.Shutdown.Drain(time.Second)
+	h.ExpectNothingSent()
+	if len(h.llm.Requests()) != 0 {
+		t.Errorf("a stranger's DM reached the LLM")
+	}
+
+	h.bot.Shutdown = NewShutdownCoordinator(h.ctx)
+	if err := h.bot.DB.AddUserToWhitelist(h.ctx, testMember.String()); err != nil {
+		t.Fatal(err)
+	}
+	h.Deliver(h.Text(testMember, testMember, "Ana", "@bot hi", testBotLID))
+	h.ExpectSentLater("test summary")
+}
+
+func TestGroupEventsAreIgnoredAfterShutdownStarts(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.Shutdown.StopIntake()
+
+	h.bot.eventHandler(&events.JoinedGroup{Sender: &testOwner, GroupInfo: types.GroupInfo{JID: testGroup}})
+	h.bot.eventHandler(&events.GroupInfo{JID: testGroup, Timestamp: h.tick(), Name: &types.GroupName{Name: "Renamed"}})
+	h.ExpectNothingSent()
+	if _, found, _ := h.bot.DB.GetGroup(h.ctx, testGroup.String()); found {
+		t.Errorf("a group event was handled during shutdown")
+	}
+}
+
+func TestGroupsAddedByOwnerAreOnboarded(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.GroupWhitelist = nil
+
+	h.bot.handleJoinedGroup(h.ctx, &events.JoinedGroup{Sender: &testOwner, GroupInfo: types.GroupInfo{JID: testGroup}})
+	if greeting := h.ExpectSent("--info"); greeting.Chat != testGroup {
+		t.Fatalf("expected the onboarding message in the group, got %+v", greeting)
+	}
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); !ok {
+		t.Errorf("group added by the owner wasn't whitelisted")
+	}
+}
+
+func TestUnapprovedGroupsAreLeftAfterDeadline(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.GroupWhitelist = nil
+	other := types.NewJID("120363000000000002", types.GroupServer)
+
+	if err := h.bot.DB.AddPendingGroup(h.ctx, testGroup.String(), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.bot.DB.AddPendingGroup(h.ctx, other.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	h.bot.resumePendingGroups(h.ctx)
+
+	if note := h.WaitSent(1)[0]; note.Chat != testOwner || !strings.Contains(note.Text, "so I left it") {
+		t.Fatalf("expected the owner to be told, got %+v", note)
+	}
+	if left := h.messenger.Left(); len(left) != 1 || left[0] != testGroup {
+		t.Fatalf("expected to leave only the expired group, left %v", left)
+	}
+
+	if err := h.bot.DB.SaveGroup(h.ctx, GroupMeta{ChatJID: other.String(), Name: "Spam", UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--deny "+other.User))
+	h.ExpectSent("Left *Spam*")
+	if left := h.messenger.Left(); len(left) != 2 || left[1] != other {
+		t.Fatalf("--deny didn't leave the group, left %v", left)
+	}
+	if groups, _ := h.bot.DB.ListGroups(h.ctx); len(groups) != 0 {
+		t.Errorf("left groups are still stored: %+v", groups)
+	}
+}
+
+func TestOwnerCanWhitelistFromInsideTheGroup(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.GroupWhitelist = nil
+
+	// Commands are ignored until the group is whitelisted, the owner's too.
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "--info"))
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--whitelist"))
+	h.ExpectNothingSent()
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); ok {
+		t.Fatal("a member whitelisted the group")
+	}
+
+	if err := h.bot.DB.AddPendingGroup(h.ctx, testGroup.String(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	h.Deliver(h.Text(testGroup, testOwner, "Owner", "--whitelist"))
+	if reply := h.ExpectSent("whitelisted now"); reply.Chat != testGroup {
+		t.Fatalf("expected the confirmation in the group, got %+v", reply)
+	}
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); !ok {
+		t.Fatal("group wasn't whitelisted")
+	}
+	if pending, _ := h.bot.DB.ListPendingGroups(h.ctx); len(pending) != 0 {
+		t.Errorf("whitelisted group is still pending: %+v", pending)
+	}
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--info"))
+	h.ExpectSent(h.bot.Prompts().InfoString)
+}
+
+func TestOwnerIsToldAboutUnlistedGroupsOnce(t *testing.T) {
+	h := newTestHarness(t)
+	other := types.NewJID("120363000000000002", types.GroupServer)
+	h.bot.SetAccount(h.ctx, "5215511111111")
+	h.messenger.groups = []*types.GroupInfo{
+		{JID: testGroup, GroupName: types.GroupName{Name: "Trip planning"}},
+		{JID: other, GroupName: types.GroupName{Name: "Book club"}},
+	}
+
+	h.bot.noticeUnlistedGroups(h.ctx)
+	note := h.ExpectSent("Book club")
+	if note.Chat != testOwner || strings.Contains(note.Text, "Trip planning") {
+		t.Fatalf("expected the owner to be told only about the unlisted group, got %+v", note)
+	}
+	if _, ok, _ := h.bot.DB.GetGroup(h.ctx, other.String()); !ok {
+		t.Errorf("unlisted group wasn't stored for --whitelist")
+	}
+
+	h.bot.noticeUnlistedGroups(h.ctx)
+	h.ExpectNothingSent()
+}
+
+func TestGroupsTheBotLeftAreForgotten(t *testing.T) {
+	h := newTestHarness(t)
+	other := types.NewJID("120363000000000002", types.GroupServer)
+	gone := types.NewJID("120363000000000003", types.GroupServer)
+	for _, chat := range []types.JID{testGroup, other, gone} {
+		if err := h.bot.DB.SaveGroup(h.ctx, GroupMeta{ChatJID: chat.String(), Name: chat.User, UpdatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.bot.handleGroupInfo(h.ctx, &events.GroupInfo{JID: other, Sender: &testMember, Timestamp: h.tick(), Leave: []types.JID{testBotLID}})
+	if _, found, _ := h.bot.DB.GetGroup(h.ctx, other.String()); found {
+		t.Errorf("the group the bot was removed from is still stored")
+	}
+	if stored := h.Stored(other); len(stored) != 0 {
+		t.Errorf("expected no context lines for a group the bot left, got %+v", stored)
+	}
+
+	// Left while offline: only --groups notices.
+	h.messenger.groups = []*types.GroupInfo{{JID: testGroup, GroupName: types.GroupName{Name: "Trip planning"}}}
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--groups"))
+	if list := h.ExpectSentLater("*Groups (1)*"); strings.Contains(list.Text, gone.User) {
+		t.Errorf("a group the bot isn't in is still listed:\n%s", list.Text)
+	}
+}
+
+func TestOwnerConsoleManagesGroupsByName(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.GroupWhitelist = nil
+	other := types.NewJID("120363000000000002", types.GroupServer)
+	h.messenger.groups = []*types.GroupInfo{
+		{JID: testGroup, GroupName: types.GroupName{Name: "Trip planning"}},
+		{JID: other, GroupName: types.GroupName{Name: "Family"}},
+	}
+	owner := func(text string) {
+		h.Deliver(h.Text(testOwner, testOwner, "Owner", text))
+	}
+
+	owner("--groups")
+	list := h.ExpectSentLater("*Groups (2)*")
+	if !strings.Contains(list.Text, "Family ("+other.User+"): not whitelisted") || !strings.Contains(list.Text, "Trip planning") {
+		t.Fatalf("unexpected group list:\n%s", list.Text)
+	}
+
+	owner("--whitelist trip")
+	h.ExpectSent("Whitelisted *Trip planning*")
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); !ok {
+		t.Fatalf("group wasn't whitelisted by name")
+	}
+	owner("--whitelist nope")
+	h.ExpectSent(`No group matches "nope"`)
+
+	owner("--broadcast Back   online\nsoon")
+	sent := h.WaitSent(2)
+	if len(sent) != 2 || sent[0].Chat != testGroup || sent[0].Text != "Back   online\nsoon" || sent[1].Text != "Sent to 1 groups." {
+		t.Fatalf("unexpected broadcast: %+v", sent)
+	}
+
+	owner("--unwhitelist " + testGroup.User)
+	h.ExpectSent("Unwhitelisted *Trip planning*")
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); ok {
+		t.Errorf("group is still whitelisted")
+	}
+
+	owner("--stats")
+	if stats := h.ExpectSent("*Groups:* 2 known, 0 whitelisted, 0 pending"); !strings.Contains(stats.Text, "Usage across all chats") {
+		t.Errorf("stats should include usage:\n%s", stats.Text)
+	}
+
+	// Other people's DMs aren't admin commands.
+	h.Deliver(h.Text(testMember, testMember, "Ana", "--groups"))
+	h.ExpectNothingSent()
+}
+
+func TestOwnerConsoleTogglesFeatures(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--disable summaries"))
+	h.ExpectSent("Turned summaries off.")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	h.ExpectSent("Summaries are turned off.")
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--disable chat"))
+	h.Sent()
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "@bot what's up?", testBotLID))
+	h.ExpectSentLater("Soy ese")
+	if len(h.llm.Requests()) != 0 {
+		t.Errorf("chat is off but the LLM was called")
+	}
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--enable summaries"))
+	h.Sent()
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--features"))
+	if list := h.ExpectSent("summaries: on"); !strings.Contains(list.Text, "chat: off") {
+		t.Errorf("unexpected feature list:\n%s", list.Text)
+	}
+
+	// Toggles outlive the cache.
+	h.bot.FeatureCache = &FeatureCache{disabled: make(map[string]bool)}
+	if h.bot.featureEnabled(featureChat) || !h.bot.featureEnabled(featureSummaries) {
+		t.Errorf("feature toggles weren't stored")
+	}
+
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--disable everything"))
+	h.ExpectSent("Usage: --disable <summaries|chat|stickers|audio>")
+}
+
+func TestPairingMovesDataToTheNewAccount(t *testing.T) {
+	h := newTestHarness(t)
+	container, err := openSessionStore(h.ctx, "file:"+t.Name()+"?mode=memory&cache=shared&_foreign_keys=on", "ERROR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Close()
+
+	// Left over from the single-account layout.
+	if err := h.bot.DB.AddGroupToWhitelist(h.ctx, testGroup.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	device := container.NewDevice()
+	device.ID = &types.JID{User: "5215511111111", Device: 3, Server: types.DefaultUserServer}
+	h.bot.Supervisor = NewConnectionSupervisor(newClient(device, "ERROR"), LoginOptions{}, "ERROR", h.bot.eventHandler)
+
+	h.bot.eventHandler(&events.PairSuccess{ID: *device.ID})
+	if got := h.bot.DB.Account(); got != "5215511111111" {
+		t.Fatalf("account after pairing: %q", got)
+	}
+	if ok, _ := h.bot.DB.IsGroupWhitelisted(h.ctx, testGroup.String()); !ok {
+		t.Errorf("the first account didn't adopt the ownerless rows")
+	}
+}
+
+func TestRePairingForgetsTheOldAccountsCaches(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.SetAccount(h.ctx, "5215511111111")
+
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "--alias Anita"))
+	h.ExpectSent("Alias has been saved.")
+	h.Deliver(h.Text(testOwner, testOwner, "Owner", "--disable chat"))
+	h.ExpectSent("Turned chat off.")
+	h.bot.LIDs = fakeLIDs{testBotPN: testBotLID}
+	h.bot.canonicalJID(testBotPN)
+
+	h.bot.SetAccount(h.ctx, "5215522222222")
+	h.bot.LIDs = fakeLIDs{}
+	if !h.bot.featureEnabled(featureChat) {
+		t.Errorf("the new account inherited the old one's feature toggles")
+	}
+	if alias := h.bot.senderAlias(&MessageContext{ChatID: testGroup, SenderID: testMember}); alias != "" {
+		t.Errorf("the new account sees the old one's alias %q", alias)
+	}
+	if got := h.bot.canonicalJID(testBotPN); got != testBotPN {
+		t.Errorf("the LID lookup was served from the old cache: %s", got)
+	}
+}
+
+func TestFailedSummaryGivesBackRateLimit(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.Quotas = QuotaConfig{UserBurst: 1}
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "hello"))
+
+	h.llm.Err = errors.New("deepseek is down")
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); !strings.Contains(sent[0].Text, "Failed to summarize") {
+		t.Fatalf("expected the summary to fail, got %+v", sent)
+	}
+
+	h.llm.Err = nil
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); sent[0].Text != "test summary" {
+		t.Fatalf("a failed summary used up the rate limit: %+v", sent)
+	}
+	h.Deliver(h.Text(testGroup, testMember, "Ana", "-s"))
+	if sent := h.WaitSent(1); !strings.Contains(sent[0].Text, "another summary in") {
+		t.Fatalf("expected the successful summary to count, got %+v", sent)
+	}
+}
+
+func TestFormattedReplyStopsAtFirstFailedPart(t *testing.T) {
+	h := newTestHarness(t)
+	h.bot.config.MaxReplyLength = 60
+	h.messenger.failAfter = 1
+
+	ctx := &MessageContext{MessageID: "Q1", ChatID: testGroup}
+	text := strings.Repeat("first part words ", 3) + "\n\n" + strings.Repeat("second part words ", 3) + "\n\n" + strings.Repeat("third part words ", 3)
+	err := h.bot.sendFormattedReply(ctx, "", text)
+	if err == nil || !strings.Contains(err.Error(), "part 2/") {
+		t.Fatalf("expected the second part to fail, got %v", err)
+	}
+	if sent := h.Sent(); len(sent) != 1 || !strings.HasPrefix(sent[0].Text, "(1/") {
+		t.Fatalf("expected only the first part, got %+v", sent)
+	}
+}
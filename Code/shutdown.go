@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ShutdownCoordinator owns the root context handed to handlers and keeps
+// count of in-flight handlers and background jobs so teardown can wait for them.
+//
+// All methods are safe on a nil coordinator, which behaves as "never shutting down"
+// for subcommands that don't run the bot.
+type ShutdownCoordinator struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// intake is cancelled by StopIntake, which stops the timers started by After.
+	intake     context.Context
+	stopIntake context.CancelFunc
+
+	mu      sync.RWMutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func NewShutdownCoordinator(parent context.Context) *ShutdownCoordinator {
+	ctx, cancel := context.WithCancel(parent)
+	intake, stopIntake := context.WithCancel(ctx)
+	return &ShutdownCoordinator{ctx: ctx, cancel: cancel, intake: intake, stopIntake: stopIntake}
+}
+
+// Context is cancelled once draining times out; long jobs should watch it.
+func (s *ShutdownCoordinator) Context() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Enter registers an incoming event. It returns false once intake has stopped,
+// in which case the caller must drop the event and not call Leave.
+func (s *ShutdownCoordinator) Enter() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closing {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *ShutdownCoordinator) Leave() {
+	if s == nil {
+		return
+	}
+	s.wg.Done()
+}
+
+// Go runs fn in a tracked goroutine. It must be called from inside an Enter/Leave
+// pair (or before StopIntake), so jobs started by a handler that is still running
+// are drained too.
+func (s *ShutdownCoordinator) Go(fn func(ctx context.Context)) {
+	if s == nil {
+		go fn(context.Background())
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn(s.ctx)
+	}()
+}
+
+// After runs fn as a tracked job once d has passed. The wait itself isn't
+// tracked, so Drain never waits out a timer: one that hasn't fired by StopIntake
+// is dropped, and whatever it was for has to be rescheduled on the next start.
+func (s *ShutdownCoordinator) After(d time.Duration, fn func(ctx context.Context)) {
+	if s == nil {
+		time.AfterFunc(d, func() { fn(context.Background()) })
+		return
+	}
+	go func() {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-s.intake.Done():
+			return
+		case <-timer.C:
+		}
+		if !s.Enter() {
+			return
+		}
+		defer s.Leave()
+		fn(s.ctx)
+	}()
+}
+
+// StopIntake makes every following Enter fail and stops pending After timers.
+func (s *ShutdownCoordinator) StopIntake() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	s.stopIntake()
+}
+
+// Drain waits for in-flight work. If it takes longer than timeout the root context
+// is cancelled and jobs get a short grace period to notice. Returns true if everything finished.
+func (s *ShutdownCoordinator) Drain(timeout time.Duration) bool {
+	if s == nil {
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return true
+	case <-time.After(timeout):
+	}
+
+	s.cancel()
+	select {
+	case <-done:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+// sleepContext sleeps for d, returning false early if ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsForHandlersAndJobs(t *testing.T) {
+	s := NewShutdownCoordinator(context.Background())
+
+	if !s.Enter() {
+		t.Fatal("Enter refused before shutdown")
+	}
+	release := make(chan struct{})
+	var finished atomic.Bool
+	s.Go(func(ctx context.Context) {
+		<-release
+		finished.Store(true)
+	})
+	s.Leave()
+
+	s.StopIntake()
+	if s.Enter() {
+		t.Fatal("Enter accepted after StopIntake")
+	}
+
+	drained := make(chan bool)
+	go func() { drained <- s.Drain(time.Minute) }()
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a job was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s.Context().Err() != nil {
+		t.Fatal("context cancelled before the timeout")
+	}
+
+	close(release)
+	if !<-drained || !finished.Load() {
+		t.Fatal("Drain didn't wait for the job to finish")
+	}
+	if s.Context().Err() == nil {
+		t.Error("context should be cancelled once drained")
+	}
+}
+
+func TestDrainCancelsSlowJobsAfterTimeout(t *testing.T) {
+	s := NewShutdownCoordinator(context.Background())
+
+	var stopped atomic.Bool
+	s.Go(func(ctx context.Context) {
+		if !sleepContext(ctx, time.Hour) {
+			stopped.Store(true)
+		}
+	})
+	s.StopIntake()
+
+	start := time.Now()
+	if !s.Drain(20 * time.Millisecond) {
+		t.Fatal("a job that watches the context should finish in the grace period")
+	}
+	if !stopped.Load() || time.Since(start) > time.Second {
+		t.Errorf("job wasn't cancelled at the timeout (took %s)", time.Since(start))
+	}
+}
+
+func TestNilShutdownCoordinator(t *testing.T) {
+	var s *ShutdownCoordinator
+	if !s.Enter() {
+		t.Fatal("nil coordinator should never refuse work")
+	}
+	s.Leave()
+	done := make(chan struct{})
+	s.Go(func(ctx context.Context) { close(done) })
+	<-done
+	s.StopIntake()
+	if !s.Drain(0) || s.Context().Err() != nil {
+		t.Error("nil coordinator should drain at once")
+	}
+}
+
+func TestDrainDoesNotWaitForPendingTimers(t *testing.T) {
+	s := NewShutdownCoordinator(context.Background())
+
+	var fired atomic.Bool
+	s.After(time.Hour, func(ctx context.Context) { fired.Store(true) })
+
+	s.StopIntake()
+	start := time.Now()
+	if !s.Drain(time.Minute) {
+		t.Fatal("Drain reported unfinished work")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("Drain waited %v for a pending timer", waited)
+	}
+	if fired.Load() {
+		t.Fatal("timer fired after StopIntake")
+	}
+}
+
+func TestTimersRunOnceDue(t *testing.T) {
+	s := NewShutdownCoordinator(context.Background())
+
+	ran := make(chan struct{})
+	s.After(10*time.Millisecond, func(ctx context.Context) { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("timer never fired")
+	}
+}
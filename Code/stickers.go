@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// stickerSize is the width and height WhatsApp expects for stickers.
+	stickerSize = 512
+	// WhatsApp rejects static stickers over 100 KB.
+	maxStickerBytes = 100 * 1024
+
+	defaultStickerDir = "stickers"
+)
+
+// Sticker is one WebP from the library, ready to upload.
+type Sticker struct {
+	Path string
+	Mood string // lowercase name of the subdirectory it came from, or "" at the top level
+	Data []byte
+	// Width and Height are the WebP's own size, sent along with it.
+	Width, Height int
+}
+
+// StickerLibrary holds the stickers found in a directory. Stickers directly in
+// it have no mood; each subdirectory ("happy/", "angry/", ...) is a mood.
+type StickerLibrary struct {
+	stickers []*Sticker
+	byMood   map[string][]*Sticker
+}
+
+// LoadStickerLibrary reads every .webp, .png, .jpg and .jpeg under dir. PNG and
+// JPEG files are converted to 512x512 WebP, which needs cwebp or ffmpeg on the
+// PATH; WebP files are used as they are. Files that can't be used are skipped
+// with a log line.
+func LoadStickerLibrary(dir string) (*StickerLibrary, error) {
+	lib := &StickerLibrary{byMood: make(map[string][]*Sticker)}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return lib, nil
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".webp" && ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if ext != ".webp" {
+			if data, err = convertToStickerWebP(data); err != nil {
+				fmt.Printf("Skipping sticker %s: %v\n", path, err)
+				return nil
+			}
+		}
+		width, height, err := webpSize(data)
+		if err != nil {
+			fmt.Printf("Skipping sticker %s: %v\n", path, err)
+			return nil
+		}
+		if width != stickerSize || height != stickerSize {
+			fmt.Printf("Warning: sticker %s is %dx%d, WhatsApp expects %dx%d\n", path, width, height, stickerSize, stickerSize)
+		}
+		if len(data) > maxStickerBytes {
+			fmt.Printf("Warning: sticker %s is %d KB, WhatsApp may reject stickers over 100 KB\n", path, len(data)/1024)
+		}
+
+		mood := ""
+		if rel, err := filepath.Rel(dir, filepath.Dir(path)); err == nil && rel != "." {
+			mood = strings.ToLower(strings.Split(filepath.ToSlash(rel), "/")[0])
+		}
+		sticker := &Sticker{Path: path, Mood: mood, Data: data, Width: width, Height: height}
+		lib.stickers = append(lib.stickers, sticker)
+		lib.byMood[mood] = append(lib.byMood[mood], sticker)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lib, nil
+}
+
+// Len is nil-safe so a bot without a library behaves like an empty one.
+func (l *StickerLibrary) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.stickers)
+}
+
+// Moods lists the moods that have at least one sticker.
+func (l *StickerLibrary) Moods() []string {
+	if l == nil {
+		return nil
+	}
+	var moods []string
+	for mood := range l.byMood {
+		if mood != "" {
+			moods = append(moods, mood)
+		}
+	}
+	sort.Strings(moods)
+	return moods
+}
+
+// Pick returns a random sticker for mood, or from the whole library if mood is
+// empty or has none. It returns nil for an empty library.
+func (l *StickerLibrary) Pick(mood string) *Sticker {
+	if l.Len() == 0 {
+		return nil
+	}
+	pool := l.byMood[strings.ToLower(mood)]
+	if mood == "" || len(pool) == 0 {
+		pool = l.stickers
+	}
+	return pool[rand.IntN(len(pool))]
+}
+
+// MoodIn returns the first mood from the library that appears as a word in text, or "".
+func (l *StickerLibrary) MoodIn(text string) string {
+	if l == nil {
+		return ""
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if _, ok := l.byMood[word]; ok {
+			return word
+		}
+	}
+	return ""
+}
+
+// ? ----------------------------------------------Conversion----------------------------------------------
+
+// convertToStickerWebP fits a PNG or JPEG into a transparent 512x512 square and
+// encodes it as WebP with cwebp, falling back to ffmpeg.
+func convertToStickerWebP(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, fitSquare(src, stickerSize)); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "sticker-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	in, out := filepath.Join(tmp, "in.png"), filepath.Join(tmp, "out.webp")
+	if err := os.WriteFile(in, pngData.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("cwebp"); err == nil {
+		cmd = exec.Command(path, "-quiet", "-q", "75", in, "-o", out)
+	} else if path, err := exec.LookPath("ffmpeg"); err == nil {
+		cmd = exec.Command(path, "-loglevel", "error", "-y", "-i", in, "-c:v", "libwebp", "-quality", "75", out)
+	} else {
+		return nil, errors.New("converting PNG/JPEG needs cwebp or ffmpeg installed, or use .webp files")
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(out)
+}
+
+// webpSize reads a WebP's canvas size from its header: VP8X for extended files,
+// otherwise the lossy (VP8) or lossless (VP8L) bitstream header.
+func webpSize(data []byte) (width, height int, err error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a WebP file")
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		width = int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16
+		height = int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16
+		return width + 1, height + 1, nil
+	case "VP8 ":
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errors.New("bad VP8 frame header")
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("bad VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown WebP chunk %q", data[12:16])
+}
+
+// fitSquare scales src to fit inside a size x size transparent square, keeping
+// its aspect ratio and centering it. Each output pixel averages the source
+// pixels it covers, which keeps downscaled stickers smooth.
+func fitSquare(src image.Image, size int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return dst
+	}
+
+	scale := float64(size) / float64(max(b.Dx(), b.Dy()))
+	w, h := max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1)
+	offX, offY := (size-w)/2, (size-h)/2
+
+	rgba := image.NewNRGBA(b)
+	draw.Draw(rgba, b, src, b.Min, draw.Src)
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := rgba.NRGBAAt(sx, sy)
+					r, g, bl, a = r+uint32(c.R), g+uint32(c.G), bl+uint32(c.B), a+uint32(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(offX+x, offY+y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// ? ----------------------------------------------Sending----------------------------------------------
+
+// sendSticker sends a sticker for mood (any sticker if mood is "") to chat,
+// uploading it first unless a recent upload is cached.
+func (b *Bot) sendSticker(ctx context.Context, chat types.JID, mood string) error {
+	sticker := b.Stickers.Pick(mood)
+	if sticker == nil {
+		return errors.New("no stickers loaded")
+	}
+	upload, err := b.uploadCached(ctx, sticker.Data, whatsmeow.MediaImage)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", sticker.Path, err)
+	}
+	return b.Messenger.SendSticker(chat, upload, sticker.Width, sticker.Height)
+}
@@ -32,11 +32,81 @@ type MessageContext struct {
 	Mentions   []string
 	IsFromMe   bool
 	RawMessage *waProto.Message
+
+	// Set when the message replies to (quotes) another one.
+	QuotedMessageID string
+	QuotedSender    string
+	QuotedText      string
+}
+
+// StoredMessage is a row of app_message_context as read back from the database.
+type StoredMessage struct {
+	MessageID        string    `json:"message_id"`
+	ChatID           string    `json:"chat_id"`
+	SenderName       string    `json:"sender_name"`
+	SenderJID        string    `json:"sender_jid,omitempty"`
+	Text             string    `json:"text,omitempty"`
+	MediaDescription string    `json:"media_description,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// AliasEntry is one row of app_aliases.
+type AliasEntry struct {
+	SenderJID string
+	Alias     string
+}
+
+// GroupMeta is what the bot knows about a group, from app_groups and app_group_participants.
+type GroupMeta struct {
+	ChatJID      string
+	Name         string
+	Topic        string
+	Participants []GroupMember
+	UpdatedAt    time.Time // last full refresh from WhatsApp; zero if only events were seen
+}
+
+// GroupMember is one participant of a group, by canonical JID (see canonicalJID).
+type GroupMember struct {
+	JID          string
+	IsAdmin      bool
+	IsSuperAdmin bool
+}
+
+// PendingGroup is a group waiting for the owner to approve or deny it.
+type PendingGroup struct {
+	ChatJID  string
+	Deadline time.Time // when the bot leaves it; zero for never
+}
+
+// LLMUsage is one model call as recorded in app_llm_usage.
+type LLMUsage struct {
+	ChatJID          string
+	RequesterJID     string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Cost             float64 // estimated, in USD
+	Success          bool
+	Error            string
+	CreatedAt        time.Time
+}
+
+// UsageTotal sums LLM usage for one chat, one requester, or everything.
+type UsageTotal struct {
+	Key    string // chat or requester JID; empty for overall totals
+	Calls  int
+	Tokens int
+	Cost   float64
 }
 
 type SummaryInfo struct {
 	MessageCount int
-	Style        string
+	Style        string    // key into PromptsConfig.SummaryStyles
+	Length       string    // "short", "medium" or "long"
+	Since        time.Time // zero unless a time range was asked for
+	SinceMe      bool      // start at the sender's last message in the chat
+	GroupName    string    // the group's subject, if known
 	Media        bool
 	Reason       bool
 }
@@ -56,3 +126,21 @@ type ImageDescriptionCache struct {
 	mu           sync.RWMutex
 	descriptions map[string]string
 }
+
+// CanonicalCache maps phone-number JIDs to the LIDs found for them.
+type CanonicalCache struct {
+	mu   sync.RWMutex
+	lids map[types.JID]types.JID
+}
+
+// FeatureCache remembers which features are turned off.
+type FeatureCache struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+// CooldownCache remembers until when each key is cooling down.
+type CooldownCache struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
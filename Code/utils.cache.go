@@ -1,6 +1,11 @@
 package main
 
-import "context"
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
 
 func isImageCached(imgCache *ImageDescriptionCache, hash string, db *AppDB) (string, error) {
 	imgCache.mu.RLock()
@@ -32,9 +37,19 @@ func setNewImageCache(imgCache *ImageDescriptionCache, hash string, description
 	return nil
 }
 
-// isAliasCached checks the in-memory alias cache for a (chatJID, senderJID) pair.
-// If not present, it falls back to the database and, on hit, populates the cache.
+// isAliasCached returns the alias of senderJID in chatJID, falling back to their
+// global alias when they have none there.
 func isAliasCached(aliasCache *AliasCache, chatJID, senderJID string, db *AppDB) (string, error) {
+	alias, err := cachedAlias(aliasCache, chatJID, senderJID, db)
+	if err != nil || alias != "" || chatJID == globalAliasChat {
+		return alias, err
+	}
+	return cachedAlias(aliasCache, globalAliasChat, senderJID, db)
+}
+
+// cachedAlias checks the in-memory alias cache for a (chatJID, senderJID) pair.
+// If not present, it falls back to the database and, on hit, populates the cache.
+func cachedAlias(aliasCache *AliasCache, chatJID, senderJID string, db *AppDB) (string, error) {
 	if aliasCache == nil {
 		return "", nil
 	}
@@ -65,10 +80,14 @@ func isAliasCached(aliasCache *AliasCache, chatJID, senderJID string, db *AppDB)
 }
 
 // setNewAliasCache updates the in-memory alias cache and persists it to the database.
+// Empty aliases are refused rather than cached.
 func setNewAliasCache(aliasCache *AliasCache, chatJID, senderJID, alias string, db *AppDB) error {
 	if aliasCache == nil {
 		return nil
 	}
+	if strings.TrimSpace(alias) == "" {
+		return errors.New("alias is empty")
+	}
 
 	key := chatJID + "|" + senderJID
 
@@ -82,3 +101,92 @@ func setNewAliasCache(aliasCache *AliasCache, chatJID, senderJID, alias string,
 
 	return nil
 }
+
+// clearAliasCache removes an alias from the cache and the database and reports whether there was one.
+func clearAliasCache(aliasCache *AliasCache, chatJID, senderJID string, db *AppDB) (bool, error) {
+	if aliasCache != nil {
+		aliasCache.mu.Lock()
+		delete(aliasCache.aliases, chatJID+"|"+senderJID)
+		aliasCache.mu.Unlock()
+	}
+
+	return db.DeleteAlias(context.Background(), chatJID, senderJID)
+}
+
+// takeCooldown reports whether key is free at now and, if so, starts a cooldown of d for it.
+// Cooldowns that have run out by now are dropped along the way.
+func takeCooldown(cooldowns *CooldownCache, key string, now time.Time, d time.Duration) bool {
+	if cooldowns == nil {
+		return true
+	}
+
+	cooldowns.mu.Lock()
+	defer cooldowns.mu.Unlock()
+
+	for k, until := range cooldowns.until {
+		if !now.Before(until) {
+			delete(cooldowns.until, k)
+		}
+	}
+	if _, ok := cooldowns.until[key]; ok {
+		return false
+	}
+	cooldowns.until[key] = now.Add(d)
+	return true
+}
+
+// releaseCooldown gives back a cooldown taken at now, for when what it guarded
+// didn't happen after all. A cooldown taken since then is left alone.
+func releaseCooldown(cooldowns *CooldownCache, key string, now time.Time, d time.Duration) {
+	if cooldowns == nil {
+		return
+	}
+
+	cooldowns.mu.Lock()
+	defer cooldowns.mu.Unlock()
+
+	if until, ok := cooldowns.until[key]; ok && until.Equal(now.Add(d)) {
+		delete(cooldowns.until, key)
+	}
+}
+
+// isFeatureDisabledCached checks the feature cache, falling back to the database on a miss.
+func isFeatureDisabledCached(featureCache *FeatureCache, feature string, db *AppDB) (bool, error) {
+	if featureCache == nil {
+		return false, nil
+	}
+
+	featureCache.mu.RLock()
+	disabled, ok := featureCache.disabled[feature]
+	featureCache.mu.RUnlock()
+
+	if ok {
+		return disabled, nil
+	}
+
+	disabled, err := db.IsFeatureDisabled(context.Background(), feature)
+	if err != nil {
+		return false, err
+	}
+
+	featureCache.mu.Lock()
+	featureCache.disabled[feature] = disabled
+	featureCache.mu.Unlock()
+
+	return disabled, nil
+}
+
+// setFeatureDisabledCache persists a feature toggle and then updates the cache.
+func setFeatureDisabledCache(featureCache *FeatureCache, feature string, disabled bool, db *AppDB) error {
+	if err := db.SetFeatureDisabled(context.Background(), feature, disabled); err != nil {
+		return err
+	}
+
+	if featureCache != nil {
+		featureCache.mu.Lock()
+		featureCache.disabled[feature] = disabled
+		featureCache.mu.Unlock()
+	}
+
+	return nil
+}
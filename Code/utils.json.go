@@ -14,6 +14,17 @@ type PromptsConfig struct {
 	LengthShort       string `json:"LengthShort"`
 	LengthMedium      string `json:"LengthMedium"`
 	LengthLong        string `json:"LengthLong"`
+
+	// SummaryStyles maps a --style name to the instructions for that kind of summary.
+	SummaryStyles       map[string]string `json:"SummaryStyles"`
+	DefaultSummaryStyle string            `json:"DefaultSummaryStyle"`
+
+	// ChunkSummaryPrompt is used for each part of a chat too long to summarize in one call.
+	ChunkSummaryPrompt string `json:"ChunkSummaryPrompt"`
+	// ConversationPrompt is used to answer people who mention the bot or reply to it.
+	ConversationPrompt string `json:"ConversationPrompt"`
+	// OnboardingMessage is posted when the bot joins a whitelisted or approved group.
+	OnboardingMessage string `json:"OnboardingMessage"`
 }
 
 // DebugPrint prints the PromptsConfig in a pretty JSON format for debugging.
@@ -54,6 +65,30 @@ type Config struct {
 	OwnerLID       string   `json:"OwnerLID"`
 	GroupWhitelist []string `json:"GroupWhitelist"`
 	UserWhitelist  []string `json:"UserWhitelist"`
+
+	// Accounts limits which paired devices are run (phone numbers or JIDs).
+	// Empty runs every device in the session store.
+	Accounts []string `json:"Accounts"`
+
+	// Models overrides the built-in context size, chunk size and pricing per model name.
+	Models map[string]ModelLimits `json:"Models"`
+	// MaxCostPerSummary caps the estimated USD cost of one summary (default 0.05).
+	MaxCostPerSummary float64 `json:"MaxCostPerSummary"`
+	// Quotas rate-limits the LLM commands per user and per chat, see QuotaConfig.
+	Quotas QuotaConfig `json:"Quotas"`
+	// MaxReplyLength is where long replies get split into "(1/3)" parts (default 3000).
+	MaxReplyLength int `json:"MaxReplyLength"`
+	// ReactToCommands reacts ⏳ to commands the bot is working on, then ✅ or ❌.
+	ReactToCommands bool `json:"ReactToCommands"`
+	// StickerDir holds the stickers sent when someone mentions the bot (default "stickers").
+	// Subdirectories are moods, e.g. stickers/happy/*.webp.
+	StickerDir string `json:"StickerDir"`
+	// AudioTriggers send music when a message contains a phrase, see AudioTrigger.
+	// Leave it out for the built-in "Bancho, lofi" style triggers; [] turns them off.
+	AudioTriggers []AudioTrigger `json:"AudioTriggers"`
+	// LeaveUnapprovedAfterMinutes makes the bot leave groups the owner didn't
+	// approve in time. 0 keeps it waiting forever.
+	LeaveUnapprovedAfterMinutes int `json:"LeaveUnapprovedAfterMinutes"`
 }
 
 // DebugPrint prints the Config in a pretty JSON format for debugging.
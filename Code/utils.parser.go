@@ -81,6 +81,19 @@ func ParseMessageEvent(evt *events.Message) (*MessageContext, error) {
 		}
 	}
 
+	// Extract the quoted message when this is a reply
+	if ext := evt.Message.GetExtendedTextMessage(); ext != nil && ext.ContextInfo.GetStanzaID() != "" {
+		info := ext.ContextInfo
+		msg.QuotedMessageID = info.GetStanzaID()
+		msg.QuotedSender = info.GetParticipant()
+		if quoted := info.GetQuotedMessage(); quoted != nil {
+			msg.QuotedText = quoted.GetConversation()
+			if msg.QuotedText == "" {
+				msg.QuotedText = quoted.GetExtendedTextMessage().GetText()
+			}
+		}
+	}
+
 	if conv := evt.Message.GetConversation(); conv != "" {
 		msg.Text = conv
 	} else if ext := evt.Message.GetExtendedTextMessage(); ext != nil && ext.Text != nil {
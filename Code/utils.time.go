@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPattern matches compact durations like "45m", "3h", "2d", "1w" or "1h30m".
+var durationPattern = regexp.MustCompile(`^(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m(?:in)?)?$`)
+
+// clockPattern matches times of day like "21:00", "9pm" or "9:30pm".
+var clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+// parseDurationExpr parses a compact duration. Plain numbers are rejected so
+// they stay free to mean a message count.
+func parseDurationExpr(s string) (time.Duration, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	m := durationPattern.FindStringSubmatch(s)
+	if m == nil || s == "" {
+		return 0, false
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute}
+	var total time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, false
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, total > 0
+}
+
+// parseClock parses a time of day into hours and minutes.
+func parseClock(s string) (int, int, bool) {
+	m := clockPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	// A bare number is a count, not a time; require ":mm" or am/pm.
+	if m[2] == "" && m[3] == "" {
+		return 0, 0, false
+	}
+
+	switch m[3] {
+	case "am":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour != 12 {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseSinceExpr reads a starting point from the front of words and returns it
+// together with how many words it used. It understands:
+//
+//	3h, 90m, 2d, 1w, 1h30m    relative to now
+//	21:00, 9pm, 9:30pm        today, or yesterday if that is still in the future
+//	today, yesterday [clock]  midnight or the given time ("hoy" and "ayer" work too)
+//	2026-10-17 [clock]        a calendar date
+func parseSinceExpr(words []string, now time.Time) (time.Time, int, error) {
+	if len(words) == 0 {
+		return time.Time{}, 0, fmt.Errorf("expected a time like 3h, 9pm, yesterday or 2026-10-17")
+	}
+	first := strings.ToLower(strings.TrimSpace(words[0]))
+
+	if d, ok := parseDurationExpr(first); ok {
+		return now.Add(-d), 1, nil
+	}
+
+	if hour, minute, ok := parseClock(first); ok {
+		since := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if since.After(now) {
+			since = since.AddDate(0, 0, -1)
+		}
+		return since, 1, nil
+	}
+
+	var day time.Time
+	switch first {
+	case "today", "hoy":
+		day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	case "yesterday", "ayer":
+		day = time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location())
+	default:
+		parsed, err := time.ParseInLocation("2006-01-02", first, now.Location())
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("can't read %q as a time, try 3h, 9pm, yesterday or 2026-10-17", words[0])
+		}
+		day = parsed
+	}
+
+	if len(words) > 1 {
+		if hour, minute, ok := parseClock(words[1]); ok {
+			return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute), 2, nil
+		}
+	}
+	return day, 1, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSinceExpr(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		words []string
+		want  time.Time
+		used  int
+	}{
+		{[]string{"3h"}, now.Add(-3 * time.Hour), 1},
+		{[]string{"1h30m"}, now.Add(-90 * time.Minute), 1},
+		{[]string{"2d"}, now.AddDate(0, 0, -2), 1},
+		{[]string{"9am"}, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), 1},
+		{[]string{"9pm"}, time.Date(2026, 10, 17, 21, 0, 0, 0, time.UTC), 1},
+		{[]string{"yesterday", "21:15", "--short"}, time.Date(2026, 10, 17, 21, 15, 0, 0, time.UTC), 2},
+		{[]string{"ayer"}, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), 1},
+		{[]string{"today", "--long"}, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), 1},
+		{[]string{"2026-10-01", "12pm"}, time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC), 2},
+	}
+	for _, tt := range tests {
+		got, used, err := parseSinceExpr(tt.words, now)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.words, err)
+			continue
+		}
+		if !got.Equal(tt.want) || used != tt.used {
+			t.Errorf("%v: got %s (%d words), want %s (%d words)", tt.words, got, used, tt.want, tt.used)
+		}
+	}
+
+	for _, bad := range [][]string{{}, {"50"}, {"soon"}, {"25:00"}, {"13pm"}} {
+		if _, _, err := parseSinceExpr(bad, now); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}